@@ -0,0 +1,87 @@
+package bitcask
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+
+	segmentstore "github.com/nitin-goyal19/bitcask/internal/segment-store"
+)
+
+// Batch collects writes which are applied to the DB all together by WriteBatch.
+// If a key is written more than once, the last write wins.
+type Batch struct {
+	records []*segmentstore.Record
+	keys    map[string]int // index in records of the write of each key
+	size    uint64
+}
+
+func NewBatch() *Batch {
+	return &Batch{keys: make(map[string]int)}
+}
+
+// Put adds the setting of key to val to the batch.
+func (batch *Batch) Put(key []byte, val []byte) error {
+	if err := checkKeyValSize(key, val); err != nil {
+		return err
+	}
+
+	batch.add(segmentstore.CreateNewRecord(bytes.Clone(key), bytes.Clone(val), segmentstore.RegularRecord))
+	return nil
+}
+
+// Delete adds the deletion of key to the batch.
+func (batch *Batch) Delete(key []byte) error {
+	if err := checkKeyValSize(key, nil); err != nil {
+		return err
+	}
+
+	batch.add(segmentstore.CreateNewRecord(bytes.Clone(key), nil, segmentstore.TombstoneRecord))
+	return nil
+}
+
+// Len returns the number of keys written by the batch.
+func (batch *Batch) Len() int {
+	return len(batch.records)
+}
+
+// Reset empties the batch so that it can be reused.
+func (batch *Batch) Reset() {
+	batch.records = batch.records[:0]
+	clear(batch.keys)
+	batch.size = 0
+}
+
+// get returns the last write of key added to the batch, if any.
+func (batch *Batch) get(key []byte) (*segmentstore.Record, bool) {
+	i, ok := batch.keys[string(key)]
+	if !ok {
+		return nil, false
+	}
+	return batch.records[i], true
+}
+
+func (batch *Batch) add(record *segmentstore.Record) {
+	if i, ok := batch.keys[string(record.Key)]; ok {
+		batch.size -= batch.records[i].WriteSize()
+		batch.records[i] = record
+	} else {
+		batch.keys[string(record.Key)] = len(batch.records)
+		batch.records = append(batch.records, record)
+	}
+	batch.size += record.WriteSize()
+}
+
+// WriteBatch applies all writes of the batch atomically: after a crash either all
+// of them or none are present, and readers never see only some of them.
+func (db *Bitcask) WriteBatch(batch *Batch) error {
+	if batch.Len() == 0 {
+		return nil
+	}
+
+	if batch.size > math.MaxUint32 {
+		return fmt.Errorf("batch can not be larger than %d bytes", math.MaxUint32)
+	}
+
+	return db.segmentStore.WriteBatch(batch.records)
+}
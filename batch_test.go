@@ -0,0 +1,104 @@
+package bitcask
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/nitin-goyal19/bitcask/config"
+	bitcask_errors "github.com/nitin-goyal19/bitcask/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWriteBatch(t *testing.T) {
+	tempDir := t.TempDir()
+	cfg := &config.Config{
+		DataDirectory: tempDir,
+		SegmentSize:   4 * config.KB,
+	}
+
+	db, error := Open("test-db", cfg)
+
+	assert.Nil(t, error)
+
+	assert.Nil(t, db.Set([]byte("deleted-key"), []byte("val")))
+	assert.Nil(t, db.WriteBatch(NewBatch()))
+
+	batch := NewBatch()
+	for i := 0; i < 100; i++ {
+		assert.Nil(t, batch.Put([]byte(fmt.Sprintf("key-%03d", i)), []byte(fmt.Sprintf("val-%03d", i))))
+	}
+	assert.Nil(t, batch.Delete([]byte("deleted-key")))
+	assert.Nil(t, batch.Put([]byte("key-000"), []byte("last-write-wins")))
+	assert.Nil(t, batch.Delete([]byte("key-001")))
+	assert.Equal(t, 101, batch.Len())
+
+	assert.Nil(t, db.WriteBatch(batch))
+
+	checkKeys := func(db *Bitcask) {
+		_, error := db.Get([]byte("deleted-key"))
+		assert.ErrorIs(t, error, bitcask_errors.ErrKeyNotFound)
+		_, error = db.Get([]byte("key-001"))
+		assert.ErrorIs(t, error, bitcask_errors.ErrKeyNotFound)
+
+		val, error := db.Get([]byte("key-000"))
+		assert.Nil(t, error)
+		assert.Equal(t, "last-write-wins", string(val))
+
+		for i := 2; i < 100; i++ {
+			val, error := db.Get([]byte(fmt.Sprintf("key-%03d", i)))
+			assert.Nil(t, error)
+			assert.Equal(t, fmt.Sprintf("val-%03d", i), string(val))
+		}
+	}
+	checkKeys(db)
+
+	// Overwrite some of the keys of the batch so that merge has to pick the live ones out of it
+	for i := 50; i < 100; i++ {
+		assert.Nil(t, db.Set([]byte(fmt.Sprintf("key-%03d", i)), []byte(fmt.Sprintf("val-%03d", i))))
+	}
+	assert.Nil(t, db.Merge())
+	checkKeys(db)
+	db.Close()
+
+	db, error = Open("test-db", cfg)
+
+	assert.Nil(t, error)
+
+	defer db.Close()
+	checkKeys(db)
+}
+
+func TestWriteBatchIsAtomicForReaders(t *testing.T) {
+	db, err := Open("test-db", &config.Config{
+		DataDirectory: t.TempDir(),
+	})
+
+	assert.Nil(t, err)
+
+	defer db.Close()
+
+	const numKeys = 20
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for round := 0; round < 200; round++ {
+			batch := NewBatch()
+			for i := 0; i < numKeys; i++ {
+				assert.Nil(t, batch.Put([]byte(fmt.Sprintf("key-%02d", i)), []byte(fmt.Sprintf("round-%03d", round))))
+			}
+			assert.Nil(t, db.WriteBatch(batch))
+		}
+	}()
+
+	for i := 0; i < 200; i++ {
+		seenValues := make(map[string]bool)
+		assert.Nil(t, db.ForEach(func(key, value []byte) error {
+			seenValues[string(value)] = true
+			return nil
+		}))
+		assert.LessOrEqual(t, len(seenValues), 1)
+	}
+	wg.Wait()
+}
@@ -19,9 +19,18 @@ func init() {
 }
 
 func BenchmarkConcurrentWrites(b *testing.B) {
+	benchmarkConcurrentWrites(b, config.SyncNever)
+}
+
+func BenchmarkConcurrentWritesSyncEveryWrite(b *testing.B) {
+	benchmarkConcurrentWrites(b, config.SyncEveryWrite)
+}
+
+func benchmarkConcurrentWrites(b *testing.B, syncPolicy config.SyncPolicy) {
 	tempDir := b.TempDir()
 	db, error := Open("test-db", &config.Config{
 		DataDirectory: tempDir,
+		SyncPolicy:    syncPolicy,
 	})
 
 	assert.Nil(b, error)
@@ -32,6 +41,8 @@ func BenchmarkConcurrentWrites(b *testing.B) {
 
 	b.ResetTimer()
 	b.ReportAllocs()
+	// Writers spend most of their time waiting on the disk, so run more of them than there are CPUs
+	b.SetParallelism(16)
 	b.RunParallel(func(pb *testing.PB) {
 		for pb.Next() {
 			idx := atomic.AddInt64(&i, 1) % int64(len(corpus))
@@ -2,10 +2,12 @@ package bitcask
 
 import (
 	"fmt"
+	"log"
 	"math"
 	"os"
 	"path/filepath"
 	"sync"
+	"time"
 
 	"github.com/nitin-goyal19/bitcask/config"
 	bitcask_errors "github.com/nitin-goyal19/bitcask/errors"
@@ -13,11 +15,16 @@ import (
 	"github.com/nitin-goyal19/bitcask/internal/utils"
 )
 
+// Name of the file in the directory of the DB on which the DB holds a lock while it is open.
+const lockFileName = "LOCK"
+
 type Bitcask struct {
 	config       *config.Config
 	dbName       string
 	segmentStore *segmentstore.SegmentStore
+	lock         *utils.FileLock
 	mu           sync.RWMutex
+	closed       bool
 }
 
 func Open(dbName string, config *config.Config) (*Bitcask, error) {
@@ -26,33 +33,141 @@ func Open(dbName string, config *config.Config) (*Bitcask, error) {
 		return nil, err
 	}
 
-	if dbName == "" {
+	if dbName == "" || dbName == "." || dbName == ".." || filepath.Base(dbName) != dbName {
 		return nil, bitcask_errors.ErrInvalidDbName
 	}
 
-	err = initializeDbDir(config)
+	dbDirPath := filepath.Join(config.DataDirectory, dbName)
+	if err := migrateLegacyLayout(dbDirPath, config); err != nil {
+		return nil, err
+	}
+	if config.ReadOnly {
+		return openReadOnly(dbName, dbDirPath, config)
+	}
+	err = initializeDbDir(dbDirPath, config)
 
 	if err != nil {
 		return nil, err
 	}
 
-	segmentStore := segmentstore.GetSegmentStore(config)
+	lock, err := utils.LockFile(filepath.Join(dbDirPath, lockFileName))
+	if err != nil {
+		return nil, err
+	}
+
+	segmentStore := segmentstore.GetSegmentStore(dbDirPath, config)
 
 	if err = segmentStore.InitializeSegmentStore(); err != nil {
+		lock.Unlock()
 		return nil, err
 	}
-	if err = segmentStore.OpenNewSegmentFile(); err != nil {
+	if err = segmentStore.OpenActiveSegment(); err != nil {
+		lock.Unlock()
 		return nil, err
 	}
+	segmentStore.StartCommitter()
+	segmentStore.StartSyncer()
+	segmentStore.StartScrubber()
 
 	return &Bitcask{
 		config:       config,
 		dbName:       dbName,
 		segmentStore: segmentStore,
+		lock:         lock,
 	}, nil
 }
 
-func initializeDbDir(config *config.Config) error {
+// openReadOnly opens an existing DB for reading only. Nothing in the directory of the
+// DB is created or changed, and the DB only takes a shared lock, so that any number of
+// read-only opens can read the DB at once, even while it is open for writing. A DB
+// opened read-only sees the DB as it was when it was opened.
+func openReadOnly(dbName string, dbDirPath string, config *config.Config) (*Bitcask, error) {
+	if _, err := os.Stat(filepath.Join(dbDirPath, config.GetSegmentDirName())); err != nil {
+		return nil, err
+	}
+
+	// The lock is only waited for while a store opened for writing deletes segment files
+	lock, err := utils.WaitLockFileShared(filepath.Join(dbDirPath, segmentstore.ReadersFileName))
+	if err != nil {
+		return nil, err
+	}
+
+	segmentStore := segmentstore.GetSegmentStore(dbDirPath, config)
+	if err := segmentStore.InitializeSegmentStore(); err != nil {
+		lock.Unlock()
+		return nil, err
+	}
+	segmentStore.StartScrubber()
+
+	return &Bitcask{
+		config:       config,
+		dbName:       dbName,
+		segmentStore: segmentStore,
+		lock:         lock,
+	}, nil
+}
+
+// migrateLegacyLayout moves the segments of a DB written before every DB had its own
+// directory, which are in the data directory itself, into the directory of the DB
+// being opened if it does not exist yet. Opening a DB whose directory exists fails
+// while such segments are in the data directory, as they would be left unused.
+func migrateLegacyLayout(dbDirPath string, config *config.Config) error {
+	legacySegmentDirPath := filepath.Join(config.DataDirectory, config.GetSegmentDirName())
+	legacyMergeDirPath := filepath.Join(config.DataDirectory, config.GetMergeSegmentDirName())
+	// Segments are moved into a temporary directory first, so that the directory of
+	// the DB only appears once all of them are in it
+	tmpDirPath := filepath.Join(config.DataDirectory, "."+filepath.Base(dbDirPath)+".migrating")
+
+	hasLegacyLayout, err := utils.DirExists(legacySegmentDirPath)
+	if err != nil {
+		return err
+	}
+	if hasLegacyLayout {
+		// The segments directory of the legacy layout holds segment files, not the
+		// directories of a DB named like it
+		if hasLegacyLayout, err = utils.DirExists(filepath.Join(legacySegmentDirPath, config.GetSegmentDirName())); err != nil {
+			return err
+		}
+		hasLegacyLayout = !hasLegacyLayout
+	}
+	isMigrating, err := utils.DirExists(tmpDirPath)
+	if err != nil {
+		return err
+	}
+	if !hasLegacyLayout && !isMigrating {
+		return nil
+	}
+
+	dbDirExists, err := utils.DirExists(dbDirPath)
+	if err != nil {
+		return err
+	}
+	if dbDirExists {
+		return fmt.Errorf("%w: move %s out of the way to open %s", bitcask_errors.ErrLegacyLayout, legacySegmentDirPath, dbDirPath)
+	}
+	if config.ReadOnly {
+		return fmt.Errorf("%w: open the DB for writing to move its segments into %s", bitcask_errors.ErrLegacyLayout, dbDirPath)
+	}
+
+	log.Printf("Moving the segments in %s into %s", config.DataDirectory, dbDirPath)
+	if err := os.MkdirAll(tmpDirPath, 0751); err != nil {
+		return err
+	}
+	for _, dirPath := range []string{legacySegmentDirPath, legacyMergeDirPath} {
+		err := os.Rename(dirPath, filepath.Join(tmpDirPath, filepath.Base(dirPath)))
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	if err := os.Rename(tmpDirPath, dbDirPath); err != nil {
+		return err
+	}
+	return utils.SyncDir(config.DataDirectory)
+}
+
+// initializeDbDir creates the directory of the DB inside the data directory, along
+// with the directories of its segments and merged segments and its readers file.
+func initializeDbDir(dbDirPath string, config *config.Config) error {
 
 	createDirIfNotExists := func(path string) error {
 		dirExists, err := utils.DirExists(path)
@@ -71,30 +186,42 @@ func initializeDbDir(config *config.Config) error {
 		return err
 	}
 
-	if err := createDirIfNotExists(filepath.Join(config.DataDirectory, config.GetSegmentDirName())); err != nil {
+	if err := createDirIfNotExists(dbDirPath); err != nil {
 		return err
 	}
 
-	if err := createDirIfNotExists(filepath.Join(config.DataDirectory, config.GetMergeSegmentDirName())); err != nil {
+	if err := createDirIfNotExists(filepath.Join(dbDirPath, config.GetSegmentDirName())); err != nil {
 		return err
 	}
-	return nil
+
+	if err := createDirIfNotExists(filepath.Join(dbDirPath, config.GetMergeSegmentDirName())); err != nil {
+		return err
+	}
+
+	// Created up front, so that read-only opens always have a file to hold their lock on
+	readersFile, err := os.OpenFile(filepath.Join(dbDirPath, segmentstore.ReadersFileName), os.O_CREATE|os.O_RDONLY, 0644)
+	if err != nil {
+		return err
+	}
+	return readersFile.Close()
 }
 
+// Close closes the DB and releases its lock. Closing a closed DB does nothing.
 func (db *Bitcask) Close() {
 	db.mu.Lock()
 	defer db.mu.Unlock()
+	if db.closed {
+		return
+	}
+	db.closed = true
 
 	db.segmentStore.Close()
+	db.lock.Unlock()
 }
 
 func (db *Bitcask) Set(key []byte, val []byte) error {
-	if len(key) > math.MaxUint16 {
-		return fmt.Errorf("key can not be larger than %d bytes", math.MaxUint16)
-	}
-
-	if len(val) > math.MaxUint32 {
-		return fmt.Errorf("key can not be larger than %d bytes", math.MaxUint32)
+	if err := checkKeyValSize(key, val); err != nil {
+		return err
 	}
 
 	record := segmentstore.CreateNewRecord(key, val, segmentstore.RegularRecord)
@@ -104,6 +231,87 @@ func (db *Bitcask) Set(key []byte, val []byte) error {
 	return nil
 }
 
+// NoExpiry is returned by TTL for keys which do not expire.
+const NoExpiry time.Duration = -1
+
+// SetWithTTL sets key to val, which expires after ttl. Expired keys are not found
+// by reads, and are removed from disk by merges.
+func (db *Bitcask) SetWithTTL(key []byte, val []byte, ttl time.Duration) error {
+	if err := checkKeyValSize(key, val); err != nil {
+		return err
+	}
+
+	if ttl <= 0 {
+		return bitcask_errors.ErrInvalidTTL
+	}
+
+	record := segmentstore.CreateExpiringRecord(key, val, time.Now().Add(ttl))
+	return db.segmentStore.Write(record, segmentstore.RegularRecord)
+}
+
+// TTL returns the time left till the value of key expires, or NoExpiry if it does not expire.
+func (db *Bitcask) TTL(key []byte) (time.Duration, error) {
+	expiresAt, err := db.segmentStore.TTL(key)
+	if err != nil {
+		return 0, err
+	}
+	if expiresAt.IsZero() {
+		return NoExpiry, nil
+	}
+	return max(time.Until(expiresAt), 0), nil
+}
+
+// Persist removes the expiry of the value of key, so that it is kept till it is
+// overwritten or deleted.
+func (db *Bitcask) Persist(key []byte) error {
+	return db.segmentStore.Persist(key)
+}
+
+// CompareAndSwap sets key to val only if its current value is expected, in one step.
+// It returns ErrKeyNotFound if the key does not exist and ErrValueMismatch if its
+// value is not expected.
+func (db *Bitcask) CompareAndSwap(key, expected, val []byte) error {
+	if err := checkKeyValSize(key, val); err != nil {
+		return err
+	}
+	return db.segmentStore.CompareAndSwap(key, expected, val)
+}
+
+// SetIfNotExists sets key to val only if the key does not exist, in one step.
+// It returns ErrKeyExists if the key exists.
+func (db *Bitcask) SetIfNotExists(key, val []byte) error {
+	if err := checkKeyValSize(key, val); err != nil {
+		return err
+	}
+	return db.segmentStore.SetIfNotExists(key, val)
+}
+
+// DeleteIfEquals deletes key only if its current value is expected, in one step.
+// It returns ErrKeyNotFound if the key does not exist and ErrValueMismatch if its
+// value is not expected.
+func (db *Bitcask) DeleteIfEquals(key, expected []byte) error {
+	if err := checkKeyValSize(key, nil); err != nil {
+		return err
+	}
+	return db.segmentStore.DeleteIfEquals(key, expected)
+}
+
+func checkKeyValSize(key, val []byte) error {
+	if len(key) > math.MaxUint16 {
+		return fmt.Errorf("key can not be larger than %d bytes", math.MaxUint16)
+	}
+
+	if len(val) > math.MaxUint32 {
+		return fmt.Errorf("value can not be larger than %d bytes", math.MaxUint32)
+	}
+	return nil
+}
+
+// Sync flushes all writes acknowledged so far to disk, whatever the SyncPolicy of the DB is.
+func (db *Bitcask) Sync() error {
+	return db.segmentStore.Sync()
+}
+
 func (db *Bitcask) Get(key []byte) ([]byte, error) {
 	value, error := db.segmentStore.Read(key)
 
@@ -119,3 +327,73 @@ func (db *Bitcask) Delete(key []byte) (bool, error) {
 
 	return ok, error
 }
+
+// Merge compacts the immutable segments of the DB, dropping overwritten values
+// and tombstones. Get, Set and Delete can be used while a merge is running.
+func (db *Bitcask) Merge() error {
+	return db.segmentStore.Merge()
+}
+
+// Verify reads every record of the DB and checks its CRC. It returns an error
+// naming the segment and offset of the first corrupt record of each corrupt segment.
+func (db *Bitcask) Verify() error {
+	return db.segmentStore.Verify()
+}
+
+// Stats describes the contents of the DB.
+type Stats struct {
+	Keys     int   // number of keys, counting keys whose values have expired till they are merged away
+	Segments int   // number of segments, including the one being written to
+	Size     int64 // size of all segments in bytes
+	Scrub    ScrubStats
+	// Reads whose record failed its CRC check, counted when VerifyChecksums is set
+	CrcFailures int64
+}
+
+// ScrubStats describes the progress of the background scrubber, and is zero if the
+// scrubber does not run.
+type ScrubStats = segmentstore.ScrubStats
+
+func (db *Bitcask) Stats() Stats {
+	stats := db.segmentStore.Stats()
+	return Stats{
+		Keys:        stats.Keys,
+		Segments:    stats.Segments,
+		Size:        stats.Size,
+		Scrub:       stats.Scrub,
+		CrcFailures: stats.CrcFailures,
+	}
+}
+
+// RepairReport describes the damage Repair found in a DB, and what it did about it.
+type RepairReport = segmentstore.RepairReport
+
+// Repair rebuilds a DB which can not be opened because of damaged files, keeping
+// every record which can still be read. Damaged segment files are moved to the
+// quarantine directory of the DB, and the report lists the keys whose values were
+// lost. The DB must not be open. If config.ReadOnly is set, the DB is only checked.
+func Repair(dbName string, config *config.Config) (*RepairReport, error) {
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+	if dbName == "" || dbName == "." || dbName == ".." || filepath.Base(dbName) != dbName {
+		return nil, bitcask_errors.ErrInvalidDbName
+	}
+
+	dbDirPath := filepath.Join(config.DataDirectory, dbName)
+	if _, err := os.Stat(filepath.Join(dbDirPath, config.GetSegmentDirName())); err != nil {
+		return nil, err
+	}
+
+	lockFile := utils.LockFile
+	if config.ReadOnly {
+		lockFile = utils.LockFileShared
+	}
+	lock, err := lockFile(filepath.Join(dbDirPath, lockFileName))
+	if err != nil {
+		return nil, err
+	}
+	defer lock.Unlock()
+
+	return segmentstore.Repair(dbDirPath, config)
+}
@@ -1,13 +1,26 @@
 package bitcask
 
 import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
 	"math/rand"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
 	"sync"
 	"testing"
+	"time"
 
 	"github.com/nitin-goyal19/bitcask/config"
 	bitcask_errors "github.com/nitin-goyal19/bitcask/errors"
+	segmentstore "github.com/nitin-goyal19/bitcask/internal/segment-store"
 	testutils "github.com/nitin-goyal19/bitcask/internal/test-utils"
+	"github.com/nitin-goyal19/bitcask/internal/utils"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -335,3 +348,1180 @@ func TestStoredDataOnReOpenSmallSegment(t *testing.T) {
 
 	wg.Wait()
 }
+
+func TestMerge(t *testing.T) {
+	tempDir := t.TempDir()
+
+	db, error := Open("test-db", &config.Config{
+		DataDirectory: tempDir,
+		SegmentSize:   64 * config.KB,
+	})
+
+	assert.Nil(t, error)
+
+	keyValMap := make(map[string][]byte)
+	for round := 0; round < 5; round++ {
+		for i := 0; i < 100; i++ {
+			key := []byte(fmt.Sprintf("key-%d", i))
+			val := testutils.GenerateBytes(uint16(rand.Intn(1 * config.KB)))
+			assert.Nil(t, db.Set(key, val))
+			keyValMap[string(key)] = val
+		}
+	}
+	for i := 0; i < 100; i += 2 {
+		key := []byte(fmt.Sprintf("key-%d", i))
+		_, error = db.Delete(key)
+		assert.Nil(t, error)
+		delete(keyValMap, string(key))
+	}
+
+	segmentDir := filepath.Join(tempDir, "test-db", "segments")
+	sizeBeforeMerge := testutils.DirSize(t, segmentDir)
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 100; i < 200; i++ {
+			key := []byte(fmt.Sprintf("key-%d", i))
+			assert.Nil(t, db.Set(key, key))
+		}
+	}()
+	assert.Nil(t, db.Merge())
+	wg.Wait()
+	for i := 100; i < 200; i++ {
+		key := fmt.Sprintf("key-%d", i)
+		keyValMap[key] = []byte(key)
+	}
+
+	assert.Less(t, testutils.DirSize(t, segmentDir), sizeBeforeMerge)
+
+	for key, val := range keyValMap {
+		storedVal, error := db.Get([]byte(key))
+		assert.Nil(t, error)
+		assert.Equal(t, val, storedVal)
+	}
+	for i := 0; i < 100; i += 2 {
+		_, error := db.Get([]byte(fmt.Sprintf("key-%d", i)))
+		assert.ErrorIs(t, error, bitcask_errors.ErrKeyNotFound)
+	}
+
+	db.Close()
+
+	db2, error := Open("test-db", &config.Config{
+		DataDirectory: tempDir,
+		SegmentSize:   64 * config.KB,
+	})
+
+	assert.Nil(t, error)
+
+	defer db2.Close()
+
+	for key, val := range keyValMap {
+		storedVal, error := db2.Get([]byte(key))
+		assert.Nil(t, error)
+		assert.Equal(t, val, storedVal)
+	}
+	for i := 0; i < 100; i += 2 {
+		_, error := db2.Get([]byte(fmt.Sprintf("key-%d", i)))
+		assert.ErrorIs(t, error, bitcask_errors.ErrKeyNotFound)
+	}
+}
+
+func TestReOpenWithHintFiles(t *testing.T) {
+	tempDir := t.TempDir()
+
+	db, error := Open("test-db", &config.Config{
+		DataDirectory: tempDir,
+		SegmentSize:   64 * config.KB,
+	})
+
+	assert.Nil(t, error)
+
+	keyValMap := make(map[string][]byte)
+	for i := 0; i < 500; i++ {
+		key := []byte(fmt.Sprintf("key-%d", rand.Intn(200)))
+		val := testutils.GenerateBytes(uint16(rand.Intn(1 * config.KB)))
+		assert.Nil(t, db.Set(key, val))
+		keyValMap[string(key)] = val
+	}
+	for i := 0; i < 200; i += 3 {
+		key := fmt.Sprintf("key-%d", i)
+		if _, ok := keyValMap[key]; ok {
+			_, error = db.Delete([]byte(key))
+			assert.Nil(t, error)
+			delete(keyValMap, key)
+		}
+	}
+
+	db.Close()
+
+	hintFiles, error := filepath.Glob(filepath.Join(tempDir, "test-db", "segments", "*.hint"))
+	assert.Nil(t, error)
+	assert.NotEmpty(t, hintFiles)
+
+	// A damaged hint file must make the segment fall back to a full scan
+	assert.Nil(t, os.WriteFile(hintFiles[0], []byte("garbage"), 0644))
+
+	for range 2 {
+		db, error = Open("test-db", &config.Config{
+			DataDirectory: tempDir,
+			SegmentSize:   64 * config.KB,
+		})
+
+		assert.Nil(t, error)
+
+		for key, val := range keyValMap {
+			storedVal, error := db.Get([]byte(key))
+			assert.Nil(t, error)
+			assert.Equal(t, val, storedVal)
+		}
+		for i := 0; i < 200; i += 3 {
+			_, error := db.Get([]byte(fmt.Sprintf("key-%d", i)))
+			assert.ErrorIs(t, error, bitcask_errors.ErrKeyNotFound)
+		}
+
+		db.Close()
+	}
+}
+
+func TestSyncPolicies(t *testing.T) {
+	configs := map[string]config.Config{
+		"never":         {SyncPolicy: config.SyncNever},
+		"every write":   {SyncPolicy: config.SyncEveryWrite},
+		"periodically":  {SyncPolicy: config.SyncPeriodically, SyncInterval: 10 * time.Millisecond},
+		"every n bytes": {SyncPolicy: config.SyncEveryNBytes, SyncBytes: 4 * config.KB},
+	}
+
+	for name, dbConfig := range configs {
+		t.Run(name, func(t *testing.T) {
+			dbConfig.DataDirectory = t.TempDir()
+			db, error := Open("test-db", &dbConfig)
+
+			assert.Nil(t, error)
+
+			keyValMap := make(map[string][]byte)
+			for i := 0; i < 50; i++ {
+				key := []byte(fmt.Sprintf("key-%d", i))
+				val := testutils.GenerateBytes(uint16(rand.Intn(1 * config.KB)))
+				assert.Nil(t, db.Set(key, val))
+				keyValMap[string(key)] = val
+			}
+			time.Sleep(20 * time.Millisecond)
+			assert.Nil(t, db.Sync())
+			db.Close()
+
+			db, error = Open("test-db", &dbConfig)
+
+			assert.Nil(t, error)
+
+			defer db.Close()
+
+			for key, val := range keyValMap {
+				storedVal, error := db.Get([]byte(key))
+				assert.Nil(t, error)
+				assert.Equal(t, val, storedVal)
+			}
+		})
+	}
+}
+
+func TestInvalidSyncConfig(t *testing.T) {
+	_, error := Open("test-db", &config.Config{
+		DataDirectory: t.TempDir(),
+		SyncPolicy:    config.SyncPeriodically,
+		SyncInterval:  -time.Second,
+	})
+	assert.ErrorIs(t, error, bitcask_errors.ErrInvalidSyncInterval)
+
+	_, error = Open("test-db", &config.Config{
+		DataDirectory: t.TempDir(),
+		SyncPolicy:    config.SyncPolicy(42),
+	})
+	assert.ErrorIs(t, error, bitcask_errors.ErrInvalidSyncPolicy)
+}
+
+// segmentFiles returns the paths of the segment files of a DB, oldest first.
+func segmentFiles(t *testing.T, segmentDir string) []string {
+	t.Helper()
+	entries, error := os.ReadDir(segmentDir)
+	assert.Nil(t, error)
+
+	var paths []string
+	for _, entry := range entries {
+		if filepath.Ext(entry.Name()) == "" {
+			paths = append(paths, filepath.Join(segmentDir, entry.Name()))
+		}
+	}
+	return paths
+}
+
+func TestOpenTruncatesTornTailRecord(t *testing.T) {
+	tempDir := t.TempDir()
+	segmentDir := filepath.Join(tempDir, "test-db", "segments")
+
+	db, error := Open("test-db", &config.Config{
+		DataDirectory: tempDir,
+	})
+
+	assert.Nil(t, error)
+
+	for i := 0; i < 10; i++ {
+		assert.Nil(t, db.Set([]byte(fmt.Sprintf("key-%d", i)), []byte(fmt.Sprintf("val-%d", i))))
+	}
+	db.Close()
+
+	// Simulate a crash in the middle of writing a record to the active segment
+	files := segmentFiles(t, segmentDir)
+	lastSegment := files[len(files)-1]
+	assert.Nil(t, os.Remove(lastSegment+".hint"))
+	info, error := os.Stat(lastSegment)
+	assert.Nil(t, error)
+	file, error := os.OpenFile(lastSegment, os.O_APPEND|os.O_WRONLY, 0644)
+	assert.Nil(t, error)
+	_, error = file.Write([]byte{0x12, 0x34, 0x56, 0x78, 0, 0, 0, 0, 0, 0, 0, 100, 0})
+	assert.Nil(t, error)
+	file.Close()
+
+	db, error = Open("test-db", &config.Config{
+		DataDirectory: tempDir,
+	})
+
+	assert.Nil(t, error)
+
+	defer db.Close()
+
+	truncatedInfo, error := os.Stat(lastSegment)
+	assert.Nil(t, error)
+	assert.Equal(t, info.Size(), truncatedInfo.Size())
+
+	for i := 0; i < 10; i++ {
+		val, error := db.Get([]byte(fmt.Sprintf("key-%d", i)))
+		assert.Nil(t, error)
+		assert.Equal(t, []byte(fmt.Sprintf("val-%d", i)), val)
+	}
+}
+
+func TestOpenWithCorruptOlderSegment(t *testing.T) {
+	tempDir := t.TempDir()
+	segmentDir := filepath.Join(tempDir, "test-db", "segments")
+
+	db, error := Open("test-db", &config.Config{
+		DataDirectory: tempDir,
+		SegmentSize:   4 * config.KB,
+	})
+
+	assert.Nil(t, error)
+
+	for i := 0; i < 100; i++ {
+		assert.Nil(t, db.Set([]byte(fmt.Sprintf("key-%d", i)), testutils.GenerateBytes(100)))
+	}
+	db.Close()
+
+	files := segmentFiles(t, segmentDir)
+	assert.Greater(t, len(files), 2)
+	for _, path := range files {
+		assert.Nil(t, os.Remove(path+".hint"))
+	}
+
+	// Flip a byte in the middle of the oldest segment
+	content, error := os.ReadFile(files[0])
+	assert.Nil(t, error)
+	content[len(content)/2] ^= 0xff
+	assert.Nil(t, os.WriteFile(files[0], content, 0644))
+
+	_, error = Open("test-db", &config.Config{
+		DataDirectory: tempDir,
+		SegmentSize:   4 * config.KB,
+	})
+	assert.True(t, errors.Is(error, bitcask_errors.ErrCrcVerificationFailed) || errors.Is(error, bitcask_errors.ErrIncompleteRecord))
+
+	db, error = Open("test-db", &config.Config{
+		DataDirectory:    tempDir,
+		SegmentSize:      4 * config.KB,
+		CorruptionPolicy: config.CorruptionSkip,
+	})
+
+	assert.Nil(t, error)
+
+	defer db.Close()
+
+	val, error := db.Get([]byte("key-99"))
+	assert.Nil(t, error)
+	assert.Len(t, val, 100)
+}
+
+func TestOpenWithCorruptSegmentMissingHintFile(t *testing.T) {
+	tempDir := t.TempDir()
+	segmentDir := filepath.Join(tempDir, "test-db", "segments")
+
+	db, error := Open("test-db", &config.Config{
+		DataDirectory: tempDir,
+		SegmentSize:   1 * config.KB,
+	})
+
+	assert.Nil(t, error)
+
+	for i := 0; i < 100; i++ {
+		assert.Nil(t, db.Set([]byte(fmt.Sprintf("key-%d", i)), []byte(fmt.Sprintf("val-%d", i))))
+	}
+	db.Close()
+
+	// An older segment whose hint file is missing is not taken for the segment which
+	// was being written to when the store went down
+	assert.Greater(t, len(segmentFiles(t, segmentDir)), 2)
+	path := filepath.Join(segmentDir, "1")
+	assert.Nil(t, os.Remove(path+".hint"))
+	content, error := os.ReadFile(path)
+	assert.Nil(t, error)
+	content[100] ^= 0xff
+	assert.Nil(t, os.WriteFile(path, content, 0644))
+
+	_, error = Open("test-db", &config.Config{
+		DataDirectory: tempDir,
+		SegmentSize:   1 * config.KB,
+	})
+	assert.True(t, errors.Is(error, bitcask_errors.ErrCrcVerificationFailed) || errors.Is(error, bitcask_errors.ErrIncompleteRecord))
+
+	info, error := os.Stat(path)
+	assert.Nil(t, error)
+	assert.Equal(t, int64(len(content)), info.Size())
+}
+
+func TestOpenLockedDb(t *testing.T) {
+	tempDir := t.TempDir()
+
+	db, error := Open("test-db", &config.Config{
+		DataDirectory: tempDir,
+	})
+
+	assert.Nil(t, error)
+
+	_, error = Open("test-db", &config.Config{
+		DataDirectory: tempDir,
+	})
+	assert.ErrorIs(t, error, bitcask_errors.ErrDatabaseLocked)
+
+	db.Close()
+
+	db, error = Open("test-db", &config.Config{
+		DataDirectory: tempDir,
+	})
+
+	assert.Nil(t, error)
+
+	db.Close()
+}
+
+func TestDbsSharingDataDirectory(t *testing.T) {
+	tempDir := t.TempDir()
+
+	db1, error := Open("db1", &config.Config{
+		DataDirectory: tempDir,
+	})
+
+	assert.Nil(t, error)
+
+	db2, error := Open("db2", &config.Config{
+		DataDirectory: tempDir,
+	})
+
+	assert.Nil(t, error)
+
+	assert.Nil(t, db1.Set([]byte("key"), []byte("db1-val")))
+	assert.Nil(t, db2.Set([]byte("key"), []byte("db2-val")))
+	assert.Nil(t, db1.Set([]byte("db1-key"), []byte("val")))
+
+	db1.Close()
+	db2.Close()
+
+	for _, dbName := range []string{"db1", "db2"} {
+		for _, dirName := range []string{"segments", "merged-segments"} {
+			dirExists, error := utils.DirExists(filepath.Join(tempDir, dbName, dirName))
+			assert.Nil(t, error)
+			assert.True(t, dirExists)
+		}
+	}
+
+	db2, error = Open("db2", &config.Config{
+		DataDirectory: tempDir,
+	})
+
+	assert.Nil(t, error)
+
+	defer db2.Close()
+
+	val, error := db2.Get([]byte("key"))
+	assert.Nil(t, error)
+	assert.Equal(t, []byte("db2-val"), val)
+
+	_, error = db2.Get([]byte("db1-key"))
+	assert.ErrorIs(t, error, bitcask_errors.ErrKeyNotFound)
+}
+
+func TestInvalidDbName(t *testing.T) {
+	for _, dbName := range []string{"", ".", "..", "a/b"} {
+		_, error := Open(dbName, &config.Config{
+			DataDirectory: t.TempDir(),
+		})
+		assert.ErrorIs(t, error, bitcask_errors.ErrInvalidDbName)
+	}
+}
+
+func TestConditionalWrites(t *testing.T) {
+	db, error := Open("test-db", &config.Config{
+		DataDirectory: t.TempDir(),
+	})
+
+	assert.Nil(t, error)
+
+	defer db.Close()
+
+	assert.Nil(t, db.SetIfNotExists([]byte("key"), []byte("val-1")))
+	assert.ErrorIs(t, db.SetIfNotExists([]byte("key"), []byte("val-2")), bitcask_errors.ErrKeyExists)
+
+	assert.ErrorIs(t, db.CompareAndSwap([]byte("key"), []byte("val-2"), []byte("val-3")), bitcask_errors.ErrValueMismatch)
+	assert.ErrorIs(t, db.CompareAndSwap([]byte("missing-key"), []byte("val-1"), []byte("val-3")), bitcask_errors.ErrKeyNotFound)
+	assert.Nil(t, db.CompareAndSwap([]byte("key"), []byte("val-1"), []byte("val-3")))
+
+	val, error := db.Get([]byte("key"))
+	assert.Nil(t, error)
+	assert.Equal(t, []byte("val-3"), val)
+
+	assert.ErrorIs(t, db.DeleteIfEquals([]byte("key"), []byte("val-1")), bitcask_errors.ErrValueMismatch)
+	assert.ErrorIs(t, db.DeleteIfEquals([]byte("missing-key"), []byte("val-3")), bitcask_errors.ErrKeyNotFound)
+	assert.Nil(t, db.DeleteIfEquals([]byte("key"), []byte("val-3")))
+
+	_, error = db.Get([]byte("key"))
+	assert.ErrorIs(t, error, bitcask_errors.ErrKeyNotFound)
+	assert.Nil(t, db.SetIfNotExists([]byte("key"), []byte("val-4")))
+}
+
+func TestConcurrentConditionalWrites(t *testing.T) {
+	db, error := Open("test-db", &config.Config{
+		DataDirectory: t.TempDir(),
+	})
+
+	assert.Nil(t, error)
+
+	defer db.Close()
+
+	assert.Nil(t, db.Set([]byte("counter"), []byte("initial")))
+
+	const numWriters = 16
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	numLeaders, numSwaps := 0, 0
+	for i := 0; i < numWriters; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			error := db.SetIfNotExists([]byte("leader"), []byte(fmt.Sprint(i)))
+			if error == nil {
+				mu.Lock()
+				numLeaders++
+				mu.Unlock()
+			} else {
+				assert.ErrorIs(t, error, bitcask_errors.ErrKeyExists)
+			}
+
+			// Every writer tries to swap the initial value for its own; only one can
+			error = db.CompareAndSwap([]byte("counter"), []byte("initial"), []byte(fmt.Sprint(i)))
+			if error == nil {
+				mu.Lock()
+				numSwaps++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, 1, numLeaders)
+	assert.Equal(t, 1, numSwaps)
+}
+
+func TestOpenLegacySegmentsWithoutHeader(t *testing.T) {
+	tempDir := t.TempDir()
+	segmentDir := filepath.Join(tempDir, "test-db", "segments")
+	cfg := &config.Config{
+		DataDirectory: tempDir,
+		SegmentSize:   4 * config.KB,
+	}
+
+	db, error := Open("test-db", cfg)
+
+	assert.Nil(t, error)
+
+	keyValMap := make(map[string][]byte)
+	for i := 0; i < 100; i++ {
+		key := fmt.Sprintf("key-%d", i)
+		keyValMap[key] = testutils.GenerateBytes(100)
+		assert.Nil(t, db.Set([]byte(key), keyValMap[key]))
+	}
+	db.Close()
+
+	// Turn the segments into segments written before segment files had a header
+	files := segmentFiles(t, segmentDir)
+	for _, path := range files {
+		content, error := os.ReadFile(path)
+		assert.Nil(t, error)
+		if len(content) > 0 {
+			assert.Equal(t, "BCSG", string(content[:4]))
+			content = content[segmentstore.SegmentHeaderSize:]
+		}
+		assert.Nil(t, os.WriteFile(path, content, 0644))
+		assert.Nil(t, os.Remove(path+".hint"))
+	}
+
+	db, error = Open("test-db", cfg)
+
+	assert.Nil(t, error)
+
+	defer db.Close()
+
+	for key, val := range keyValMap {
+		storedVal, error := db.Get([]byte(key))
+		assert.Nil(t, error)
+		assert.Equal(t, val, storedVal)
+	}
+
+	// Merge rewrites the segments with a header
+	assert.Nil(t, db.Merge())
+	for _, path := range segmentFiles(t, segmentDir) {
+		content, error := os.ReadFile(path)
+		assert.Nil(t, error)
+		if len(content) > 0 {
+			assert.Equal(t, "BCSG", string(content[:4]))
+		}
+	}
+	for key, val := range keyValMap {
+		storedVal, error := db.Get([]byte(key))
+		assert.Nil(t, error)
+		assert.Equal(t, val, storedVal)
+	}
+}
+
+// legacyFrame encodes a record as the WAL frame written by the first version of the
+// DB, before records had a sequence number or an expiry.
+func legacyFrame(recordType segmentstore.RecordType, key, val []byte) []byte {
+	record := []byte{recordType}
+	record = binary.BigEndian.AppendUint64(record, uint64(time.Now().UnixNano()))
+	record = binary.BigEndian.AppendUint16(record, uint16(len(key)))
+	record = binary.BigEndian.AppendUint32(record, uint32(len(val)))
+	record = append(append(record, key...), val...)
+
+	lenBuf := binary.BigEndian.AppendUint64(nil, uint64(len(record)))
+	crcSum := crc32.Update(crc32.ChecksumIEEE(lenBuf), crc32.IEEETable, record)
+	frame := binary.BigEndian.AppendUint32(nil, crcSum)
+	return append(append(frame, lenBuf...), record...)
+}
+
+func TestOpenLegacyLayout(t *testing.T) {
+	tempDir := t.TempDir()
+	cfg := &config.Config{
+		DataDirectory: tempDir,
+	}
+
+	// The first version of the DB kept its segments right in the data directory
+	legacySegmentDir := filepath.Join(tempDir, "segments")
+	assert.Nil(t, os.Mkdir(legacySegmentDir, 0751))
+	assert.Nil(t, os.Mkdir(filepath.Join(tempDir, "merged-segments"), 0751))
+	var content []byte
+	content = append(content, legacyFrame(segmentstore.RegularRecord, []byte("key-1"), []byte("val-1"))...)
+	content = append(content, legacyFrame(segmentstore.RegularRecord, []byte("key-2"), []byte("val-2"))...)
+	assert.Nil(t, os.WriteFile(filepath.Join(legacySegmentDir, "1"), content, 0644))
+	content = nil
+	content = append(content, legacyFrame(segmentstore.RegularRecord, []byte("key-1"), []byte("new-val-1"))...)
+	content = append(content, legacyFrame(segmentstore.TombstoneRecord, []byte("key-2"), nil)...)
+	content = append(content, legacyFrame(segmentstore.RegularRecord, []byte("key-3"), []byte("val-3"))...)
+	assert.Nil(t, os.WriteFile(filepath.Join(legacySegmentDir, "2"), content, 0644))
+	assert.Nil(t, os.WriteFile(filepath.Join(legacySegmentDir, "3"), nil, 0644))
+
+	// The segments can only be moved by opening the DB for writing
+	_, error := Open("test-db", &config.Config{
+		DataDirectory: tempDir,
+		ReadOnly:      true,
+	})
+	assert.ErrorIs(t, error, bitcask_errors.ErrLegacyLayout)
+
+	db, error := Open("test-db", cfg)
+
+	assert.Nil(t, error)
+
+	assert.NoDirExists(t, legacySegmentDir)
+	assert.NoDirExists(t, filepath.Join(tempDir, "merged-segments"))
+	assert.FileExists(t, filepath.Join(tempDir, "test-db", "segments", "1"))
+
+	got, error := db.Get([]byte("key-1"))
+	assert.Nil(t, error)
+	assert.Equal(t, []byte("new-val-1"), got)
+	_, error = db.Get([]byte("key-2"))
+	assert.ErrorIs(t, error, bitcask_errors.ErrKeyNotFound)
+	got, error = db.Get([]byte("key-3"))
+	assert.Nil(t, error)
+	assert.Equal(t, []byte("val-3"), got)
+	assert.Nil(t, db.Set([]byte("key-4"), []byte("val-4")))
+	db.Close()
+
+	db, error = Open("test-db", cfg)
+
+	assert.Nil(t, error)
+
+	got, error = db.Get([]byte("key-4"))
+	assert.Nil(t, error)
+	assert.Equal(t, []byte("val-4"), got)
+	db.Close()
+
+	// Segments of the legacy layout next to an existing DB would be left unused
+	assert.Nil(t, os.Mkdir(legacySegmentDir, 0751))
+	_, error = Open("test-db", cfg)
+	assert.ErrorIs(t, error, bitcask_errors.ErrLegacyLayout)
+}
+
+func TestOpenSegmentWithInvalidHeader(t *testing.T) {
+	tempDir := t.TempDir()
+	segmentDir := filepath.Join(tempDir, "test-db", "segments")
+	cfg := &config.Config{
+		DataDirectory: tempDir,
+	}
+
+	db, error := Open("test-db", cfg)
+
+	assert.Nil(t, error)
+
+	assert.Nil(t, db.Set([]byte("key"), []byte("val")))
+	db.Close()
+
+	path := segmentFiles(t, segmentDir)[0]
+	content, error := os.ReadFile(path)
+	assert.Nil(t, error)
+
+	// A newer format version
+	newerVersion := bytes.Clone(content)
+	newerVersion[5]++
+	binary.BigEndian.PutUint32(newerVersion[segmentstore.SegmentHeaderSize-4:], crc32.ChecksumIEEE(newerVersion[:segmentstore.SegmentHeaderSize-4]))
+	assert.Nil(t, os.WriteFile(path, newerVersion, 0644))
+	_, error = Open("test-db", cfg)
+	assert.ErrorIs(t, error, bitcask_errors.ErrUnknownSegmentVersion)
+
+	// A damaged header
+	damaged := bytes.Clone(content)
+	damaged[10] ^= 0xff
+	assert.Nil(t, os.WriteFile(path, damaged, 0644))
+	_, error = Open("test-db", cfg)
+	assert.ErrorIs(t, error, bitcask_errors.ErrInvalidSegmentHeader)
+
+	// The header of another segment, in a DB without a manifest listing its segments
+	assert.Nil(t, os.Remove(filepath.Join(tempDir, "test-db", "MANIFEST")))
+	assert.Nil(t, os.WriteFile(path, content, 0644))
+	assert.Nil(t, os.Rename(path, filepath.Join(segmentDir, "0")))
+	assert.Nil(t, os.Remove(path+".hint"))
+	_, error = Open("test-db", cfg)
+	assert.ErrorIs(t, error, bitcask_errors.ErrInvalidSegmentHeader)
+}
+
+func TestReOpenOrdersRecordsBySequenceNumber(t *testing.T) {
+	tempDir := t.TempDir()
+	segmentDir := filepath.Join(tempDir, "test-db", "segments")
+	cfg := &config.Config{
+		DataDirectory: tempDir,
+	}
+
+	for _, val := range []string{"old-val", "new-val"} {
+		db, error := Open("test-db", cfg)
+
+		assert.Nil(t, error)
+
+		assert.Nil(t, db.Set([]byte("key"), []byte(val)))
+		db.Close()
+	}
+
+	// Move the timestamp of the newer record back in time, as if the clock jumped
+	// backwards between the writes
+	files := segmentFiles(t, segmentDir)
+	newestSegment := files[len(files)-1]
+	content, error := os.ReadFile(newestSegment)
+	assert.Nil(t, error)
+	frame := content[segmentstore.SegmentHeaderSize:]
+	frameSize := segmentstore.WalRecordHeaderSize + binary.BigEndian.Uint64(frame[4:])
+	binary.BigEndian.PutUint64(frame[segmentstore.WalRecordHeaderSize+1:], 1)
+	binary.BigEndian.PutUint32(frame, crc32.ChecksumIEEE(frame[4:frameSize]))
+	assert.Nil(t, os.WriteFile(newestSegment, content, 0644))
+	for _, path := range files {
+		assert.Nil(t, os.Remove(path+".hint"))
+	}
+
+	db, error := Open("test-db", cfg)
+
+	assert.Nil(t, error)
+
+	defer db.Close()
+
+	val, error := db.Get([]byte("key"))
+	assert.Nil(t, error)
+	assert.Equal(t, []byte("new-val"), val)
+}
+
+func TestSegmentIdsAreSequential(t *testing.T) {
+	tempDir := t.TempDir()
+	segmentDir := filepath.Join(tempDir, "test-db", "segments")
+	cfg := &config.Config{
+		DataDirectory: tempDir,
+		SegmentSize:   1 * config.KB,
+	}
+
+	db, error := Open("test-db", cfg)
+
+	assert.Nil(t, error)
+
+	for i := 0; i < 400; i++ {
+		assert.Nil(t, db.Set([]byte(fmt.Sprintf("key-%d", i%20)), []byte(fmt.Sprintf("val-%d", i))))
+	}
+	db.Close()
+
+	segmentIds := func() []int {
+		var ids []int
+		for _, path := range segmentFiles(t, segmentDir) {
+			id, error := strconv.Atoi(filepath.Base(path))
+			assert.Nil(t, error)
+			ids = append(ids, id)
+		}
+		slices.Sort(ids)
+		return ids
+	}
+	ids := segmentIds()
+	assert.Greater(t, len(ids), 10)
+	for i, id := range ids {
+		assert.Equal(t, i+1, id)
+	}
+
+	// A DB without a manifest continues after its newest segment
+	assert.Nil(t, os.Remove(filepath.Join(tempDir, "test-db", "MANIFEST")))
+
+	db, error = Open("test-db", cfg)
+
+	assert.Nil(t, error)
+
+	defer db.Close()
+
+	for i := 400; i < 500; i++ {
+		assert.Nil(t, db.Set([]byte(fmt.Sprintf("key-%d", i%20)), []byte(fmt.Sprintf("val-%d", i))))
+	}
+	newIds := segmentIds()[len(ids):]
+	assert.NotEmpty(t, newIds)
+	assert.Equal(t, ids[len(ids)-1]+1, newIds[0])
+	for i := 480; i < 500; i++ {
+		val, error := db.Get([]byte(fmt.Sprintf("key-%d", i%20)))
+		assert.Nil(t, error)
+		assert.Equal(t, []byte(fmt.Sprintf("val-%d", i)), val)
+	}
+}
+
+func TestOpenWithCorruptManifest(t *testing.T) {
+	tempDir := t.TempDir()
+	cfg := &config.Config{
+		DataDirectory: tempDir,
+	}
+
+	db, error := Open("test-db", cfg)
+
+	assert.Nil(t, error)
+
+	db.Close()
+
+	manifestPath := filepath.Join(tempDir, "test-db", "MANIFEST")
+	content, error := os.ReadFile(manifestPath)
+	assert.Nil(t, error)
+	assert.Nil(t, os.WriteFile(manifestPath, bytes.Replace(content, []byte("next-segment-id "), []byte("next-segment-id 9"), 1), 0644))
+
+	_, error = Open("test-db", cfg)
+	assert.NotNil(t, error)
+}
+
+func TestManifestListsSegments(t *testing.T) {
+	tempDir := t.TempDir()
+	segmentDir := filepath.Join(tempDir, "test-db", "segments")
+	manifestPath := filepath.Join(tempDir, "test-db", "MANIFEST")
+	cfg := &config.Config{
+		DataDirectory: tempDir,
+		SegmentSize:   1 * config.KB,
+	}
+
+	db, error := Open("test-db", cfg)
+
+	assert.Nil(t, error)
+
+	for i := 0; i < 100; i++ {
+		assert.Nil(t, db.Set([]byte(fmt.Sprintf("key-%d", i%20)), []byte(fmt.Sprintf("val-%d", i))))
+	}
+	assert.Nil(t, db.Merge())
+	db.Close()
+
+	content, error := os.ReadFile(manifestPath)
+	assert.Nil(t, error)
+	var listedFiles []string
+	for _, line := range strings.Split(string(content), "\n") {
+		if fields := strings.Fields(line); len(fields) > 0 && fields[0] == "segments" {
+			for _, segmentId := range fields[1:] {
+				listedFiles = append(listedFiles, filepath.Join(segmentDir, segmentId))
+			}
+		}
+	}
+	assert.ElementsMatch(t, segmentFiles(t, segmentDir), listedFiles)
+
+	// Files left behind by a rotation or a merge which did not finish
+	segment, error := os.ReadFile(listedFiles[0])
+	assert.Nil(t, error)
+	assert.Nil(t, os.WriteFile(filepath.Join(segmentDir, "999"), segment, 0644))
+	assert.Nil(t, os.WriteFile(filepath.Join(segmentDir, "999.hint"), nil, 0644))
+	assert.Nil(t, os.WriteFile(filepath.Join(tempDir, "test-db", "merged-segments", "1000"), segment, 0644))
+	assert.Nil(t, os.WriteFile(filepath.Join(segmentDir, "README"), nil, 0644))
+
+	db, error = Open("test-db", cfg)
+
+	assert.Nil(t, error)
+
+	for i := 80; i < 100; i++ {
+		val, error := db.Get([]byte(fmt.Sprintf("key-%d", i%20)))
+		assert.Nil(t, error)
+		assert.Equal(t, []byte(fmt.Sprintf("val-%d", i)), val)
+	}
+	db.Close()
+
+	for _, path := range []string{filepath.Join(segmentDir, "999"), filepath.Join(segmentDir, "999.hint"), filepath.Join(tempDir, "test-db", "merged-segments", "1000")} {
+		_, error := os.Stat(path)
+		assert.True(t, os.IsNotExist(error))
+	}
+	_, error = os.Stat(filepath.Join(segmentDir, "README"))
+	assert.Nil(t, error)
+
+	// A segment listed in the manifest must not go missing
+	assert.Nil(t, os.Remove(listedFiles[0]))
+	_, error = Open("test-db", cfg)
+	assert.ErrorIs(t, error, bitcask_errors.ErrMissingSegment)
+}
+
+func TestReOpenReusesNewestSegment(t *testing.T) {
+	tempDir := t.TempDir()
+	segmentDir := filepath.Join(tempDir, "test-db", "segments")
+	cfg := &config.Config{
+		DataDirectory: tempDir,
+		SegmentSize:   64 * config.KB,
+	}
+
+	for i := 0; i < 10; i++ {
+		db, error := Open("test-db", cfg)
+
+		assert.Nil(t, error)
+
+		assert.Nil(t, db.Set([]byte(fmt.Sprintf("key-%d", i)), []byte(fmt.Sprintf("val-%d", i))))
+		db.Close()
+	}
+	assert.Len(t, segmentFiles(t, segmentDir), 1)
+
+	db, error := Open("test-db", cfg)
+
+	assert.Nil(t, error)
+
+	assert.Nil(t, db.Set([]byte("key-10"), []byte("val-10")))
+
+	// Copy the DB while it is open, as if it went down without being closed
+	copyDir := filepath.Join(t.TempDir(), "test-db")
+	assert.Nil(t, os.CopyFS(copyDir, os.DirFS(filepath.Join(tempDir, "test-db"))))
+	db.Close()
+
+	for _, dataDirectory := range []string{tempDir, filepath.Dir(copyDir)} {
+		db, error := Open("test-db", &config.Config{
+			DataDirectory: dataDirectory,
+			SegmentSize:   64 * config.KB,
+		})
+
+		assert.Nil(t, error)
+
+		for i := 0; i <= 10; i++ {
+			val, error := db.Get([]byte(fmt.Sprintf("key-%d", i)))
+			assert.Nil(t, error)
+			assert.Equal(t, []byte(fmt.Sprintf("val-%d", i)), val)
+		}
+		db.Close()
+	}
+}
+
+func TestOpenWithOtherSegmentSize(t *testing.T) {
+	tempDir := t.TempDir()
+
+	db, error := Open("test-db", &config.Config{
+		DataDirectory: tempDir,
+		SegmentSize:   1 * config.KB,
+	})
+
+	assert.Nil(t, error)
+
+	assert.Nil(t, db.Set([]byte("key"), []byte("val")))
+	db.Close()
+
+	for _, readOnly := range []bool{false, true} {
+		_, error = Open("test-db", &config.Config{
+			DataDirectory: tempDir,
+			SegmentSize:   2 * config.KB,
+			ReadOnly:      readOnly,
+		})
+		assert.ErrorIs(t, error, bitcask_errors.ErrConfigMismatch)
+	}
+
+	// A DB opened without a SegmentSize keeps the one it was written with
+	cfg := &config.Config{
+		DataDirectory: tempDir,
+	}
+	db, error = Open("test-db", cfg)
+
+	assert.Nil(t, error)
+
+	assert.Equal(t, int64(1*config.KB), cfg.SegmentSize)
+	db.Close()
+
+	_, error = Open("test-db", &config.Config{
+		DataDirectory: tempDir,
+		SegmentSize:   2 * config.KB,
+	})
+	assert.ErrorIs(t, error, bitcask_errors.ErrConfigMismatch)
+}
+
+func TestCloseTwice(t *testing.T) {
+	db, error := Open("test-db", &config.Config{
+		DataDirectory: t.TempDir(),
+		SyncPolicy:    config.SyncPeriodically,
+		ScrubInterval: time.Millisecond,
+	})
+
+	assert.Nil(t, error)
+
+	assert.Nil(t, db.Set([]byte("key"), []byte("val")))
+	db.Close()
+	assert.NotPanics(t, db.Close)
+	assert.ErrorIs(t, db.Set([]byte("key"), []byte("val")), bitcask_errors.ErrDbClosed)
+}
+
+func TestVerify(t *testing.T) {
+	tempDir := t.TempDir()
+	segmentDir := filepath.Join(tempDir, "test-db", "segments")
+	cfg := &config.Config{
+		DataDirectory: tempDir,
+		SegmentSize:   1 * config.KB,
+	}
+
+	db, error := Open("test-db", cfg)
+
+	assert.Nil(t, error)
+
+	for i := 0; i < 100; i++ {
+		assert.Nil(t, db.Set([]byte(fmt.Sprintf("key-%d", i%20)), []byte(fmt.Sprintf("val-%d", i))))
+	}
+	assert.Nil(t, db.Verify())
+
+	stats := db.Stats()
+	assert.Equal(t, 20, stats.Keys)
+	assert.Equal(t, len(segmentFiles(t, segmentDir)), stats.Segments)
+	var size int64
+	for _, path := range segmentFiles(t, segmentDir) {
+		fileInfo, error := os.Stat(path)
+		assert.Nil(t, error)
+		size += fileInfo.Size()
+	}
+	assert.Equal(t, size, stats.Size)
+	db.Close()
+
+	// Damage a value, which goes unnoticed when the DB is opened from hint files
+	path := segmentFiles(t, segmentDir)[1]
+	content, error := os.ReadFile(path)
+	assert.Nil(t, error)
+	content[len(content)-1] ^= 0xff
+	assert.Nil(t, os.WriteFile(path, content, 0644))
+
+	db, error = Open("test-db", cfg)
+
+	assert.Nil(t, error)
+
+	defer db.Close()
+
+	error = db.Verify()
+	assert.ErrorIs(t, error, bitcask_errors.ErrCrcVerificationFailed)
+	assert.Contains(t, error.Error(), fmt.Sprintf("segment %s ", filepath.Base(path)))
+}
+
+func TestVerifyChecksums(t *testing.T) {
+	tempDir := t.TempDir()
+	segmentDir := filepath.Join(tempDir, "test-db", "segments")
+	cfg := &config.Config{
+		DataDirectory:   tempDir,
+		SegmentSize:     1 * config.KB,
+		VerifyChecksums: true,
+	}
+
+	db, error := Open("test-db", cfg)
+
+	assert.Nil(t, error)
+
+	for i := 0; i < 20; i++ {
+		assert.Nil(t, db.Set([]byte(fmt.Sprintf("key-%d", i)), []byte(fmt.Sprintf("val-%d", i))))
+	}
+	batch := NewBatch()
+	assert.Nil(t, batch.Put([]byte("batch-key-1"), []byte("batch-val-1")))
+	assert.Nil(t, batch.Put([]byte("batch-key-2"), []byte("batch-val-2")))
+	assert.Nil(t, db.WriteBatch(batch))
+
+	// Values in the active segment, in batches and in merged segments are all verified
+	for i := 0; i < 2; i++ {
+		for i := 0; i < 20; i++ {
+			got, error := db.Get([]byte(fmt.Sprintf("key-%d", i)))
+			assert.Nil(t, error)
+			assert.Equal(t, []byte(fmt.Sprintf("val-%d", i)), got)
+		}
+		got, error := db.Get([]byte("batch-key-2"))
+		assert.Nil(t, error)
+		assert.Equal(t, []byte("batch-val-2"), got)
+		assert.Nil(t, db.Merge())
+	}
+	assert.Zero(t, db.Stats().CrcFailures)
+	db.Close()
+
+	// Damage the last byte of the key of the first record, which is not part of its value
+	var path string
+	var content []byte
+	offset := -1
+	for _, path = range segmentFiles(t, segmentDir) {
+		content, error = os.ReadFile(path)
+		assert.Nil(t, error)
+		if offset = bytes.Index(content, []byte("val-0")); offset >= 0 {
+			break
+		}
+	}
+	assert.Greater(t, offset, 0)
+	content[offset-1] ^= 0xff
+	assert.Nil(t, os.WriteFile(path, content, 0644))
+
+	db, error = Open("test-db", &config.Config{
+		DataDirectory: tempDir,
+		SegmentSize:   1 * config.KB,
+	})
+
+	assert.Nil(t, error)
+
+	got, error := db.Get([]byte("key-0"))
+	assert.Nil(t, error)
+	assert.Equal(t, []byte("val-0"), got)
+	db.Close()
+
+	db, error = Open("test-db", cfg)
+
+	assert.Nil(t, error)
+
+	defer db.Close()
+
+	_, error = db.Get([]byte("key-0"))
+	assert.ErrorIs(t, error, bitcask_errors.ErrCrcVerificationFailed)
+	assert.Contains(t, error.Error(), fmt.Sprintf("segment %s at offset ", filepath.Base(path)))
+	_, error = db.Get([]byte("key-0"))
+	assert.ErrorIs(t, error, bitcask_errors.ErrCrcVerificationFailed)
+	assert.Equal(t, int64(2), db.Stats().CrcFailures)
+
+	got, error = db.Get([]byte("key-1"))
+	assert.Nil(t, error)
+	assert.Equal(t, []byte("val-1"), got)
+}
+
+func TestVerifyChecksumsCorruptLength(t *testing.T) {
+	tempDir := t.TempDir()
+	cfg := &config.Config{
+		DataDirectory:   tempDir,
+		SegmentSize:     1 * config.KB,
+		VerifyChecksums: true,
+	}
+
+	db, error := Open("test-db", cfg)
+
+	assert.Nil(t, error)
+
+	for i := 0; i < 20; i++ {
+		assert.Nil(t, db.Set([]byte(fmt.Sprintf("key-%d", i)), []byte(fmt.Sprintf("val-%d", i))))
+	}
+	db.Close()
+
+	// Damage the length of the frame of key-0, which goes unnoticed when the DB is opened from hint files
+	path := filepath.Join(tempDir, "test-db", "segments", "1")
+	content, error := os.ReadFile(path)
+	assert.Nil(t, error)
+	binary.BigEndian.PutUint64(content[segmentstore.SegmentHeaderSize+4:], 1<<62)
+	assert.Nil(t, os.WriteFile(path, content, 0644))
+
+	db, error = Open("test-db", cfg)
+
+	assert.Nil(t, error)
+
+	defer db.Close()
+
+	_, error = db.Get([]byte("key-0"))
+	assert.ErrorIs(t, error, bitcask_errors.ErrIncompleteRecord)
+	assert.Contains(t, error.Error(), fmt.Sprintf("segment 1 at offset %d", segmentstore.SegmentHeaderSize))
+	assert.Equal(t, int64(1), db.Stats().CrcFailures)
+
+	got, error := db.Get([]byte("key-1"))
+	assert.Nil(t, error)
+	assert.Equal(t, []byte("val-1"), got)
+}
+
+func TestReadFrames(t *testing.T) {
+	tempDir := t.TempDir()
+	segmentDir := filepath.Join(tempDir, "test-db", "segments")
+
+	db, error := Open("test-db", &config.Config{
+		DataDirectory: tempDir,
+	})
+
+	assert.Nil(t, error)
+
+	assert.Nil(t, db.Set([]byte("key-1"), []byte("val-1")))
+	batch := NewBatch()
+	assert.Nil(t, batch.Put([]byte("key-2"), []byte("val-2")))
+	assert.Nil(t, batch.Delete([]byte("key-1")))
+	assert.Nil(t, db.WriteBatch(batch))
+	assert.Nil(t, db.Set([]byte("key-3"), []byte("val-3")))
+	db.Close()
+
+	path := segmentFiles(t, segmentDir)[0]
+	content, error := os.ReadFile(path)
+	assert.Nil(t, error)
+
+	readFrames := func() []segmentstore.Frame {
+		file, error := os.Open(path)
+		assert.Nil(t, error)
+		defer file.Close()
+		return slices.Collect(segmentstore.ReadFrames(file, segmentstore.SegmentHeaderSize, int64(len(content))))
+	}
+
+	frames := readFrames()
+	assert.Len(t, frames, 3)
+	for _, frame := range frames {
+		assert.Nil(t, frame.Err)
+	}
+	assert.Equal(t, int64(segmentstore.SegmentHeaderSize), frames[0].Offset)
+	assert.Equal(t, int64(len(content)), frames[2].Offset+frames[2].Size)
+	assert.Equal(t, segmentstore.BatchRecord, frames[1].Record.Type())
+	assert.Equal(t, frames[0].Record.Seq()+1, frames[1].Record.Seq())
+	records := frames[1].Record.Records()
+	assert.Len(t, records, 2)
+	assert.Equal(t, []byte("key-2"), records[0].Key)
+	assert.True(t, records[1].IsTombstone())
+
+	// A damaged frame is skipped, and the file ending in the middle of a frame ends the frames
+	content[frames[1].Offset+frames[1].Size-1] ^= 0xff
+	assert.Nil(t, os.WriteFile(path, content[:len(content)-1], 0644))
+	content = content[:len(content)-1]
+
+	frames = readFrames()
+	assert.Len(t, frames, 3)
+	assert.Nil(t, frames[0].Err)
+	assert.ErrorIs(t, frames[1].Err, bitcask_errors.ErrCrcVerificationFailed)
+	assert.Nil(t, frames[1].Record)
+	assert.ErrorIs(t, frames[2].Err, bitcask_errors.ErrIncompleteRecord)
+}
@@ -0,0 +1,249 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	bitcask_errors "github.com/nitin-goyal19/bitcask/errors"
+	segmentstore "github.com/nitin-goyal19/bitcask/internal/segment-store"
+)
+
+// Flags of the inspect command
+var (
+	inspectJSON    bool
+	inspectHexdump bool
+)
+
+// Damaged frames longer than this are cut short in hexdumps.
+const maxHexdumpSize = 1024
+
+type inspectedHeader struct {
+	Version   uint16     `json:"version"`
+	SegmentId int64      `json:"segmentId,omitempty"`
+	CreatedAt *time.Time `json:"createdAt,omitempty"`
+	Error     string     `json:"error,omitempty"`
+}
+
+type inspectedRecord struct {
+	Type      string     `json:"type"`
+	Seq       uint64     `json:"seq"`
+	Timestamp time.Time  `json:"timestamp"`
+	ExpiresAt *time.Time `json:"expiresAt,omitempty"`
+	KeySize   int        `json:"keySize"`
+	ValueSize int        `json:"valueSize"`
+	// records of a batch
+	Records []inspectedRecord `json:"records,omitempty"`
+}
+
+type inspectedFrame struct {
+	Offset int64  `json:"offset"`
+	Size   int64  `json:"size"`
+	Crc    string `json:"crc"`
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+	*inspectedRecord
+	Hexdump string `json:"hexdump,omitempty"`
+}
+
+type inspectedSegment struct {
+	File          string           `json:"file"`
+	Size          int64            `json:"size"`
+	Header        inspectedHeader  `json:"header"`
+	Frames        []inspectedFrame `json:"frames"`
+	DamagedFrames int              `json:"damagedFrames"`
+}
+
+// runInspect prints the WAL frames of a segment file, which does not have to be in
+// a DB, and fails if any of them is damaged.
+func runInspect(args []string) error {
+	if len(args) != 1 {
+		return errUsage
+	}
+
+	file, err := os.Open(args[0])
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	segment, err := inspectSegment(file)
+	if err != nil {
+		return err
+	}
+	segment.File = args[0]
+
+	if inspectJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(segment); err != nil {
+			return err
+		}
+	} else if err := printSegment(os.Stdout, segment); err != nil {
+		return err
+	}
+
+	if segment.DamagedFrames > 0 {
+		return fmt.Errorf("%d damaged frames", segment.DamagedFrames)
+	}
+	return nil
+}
+
+func inspectSegment(file *os.File) (*inspectedSegment, error) {
+	fileInfo, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	segment := &inspectedSegment{Size: fileInfo.Size()}
+
+	var dataStart int64
+	header, err := segmentstore.ReadSegmentHeader(file, fileInfo.Size())
+	switch {
+	case errors.Is(err, bitcask_errors.ErrInvalidSegmentHeader):
+		// The frames are still where they are in a segment file with a valid header
+		segment.Header = inspectedHeader{Version: segmentstore.SegmentFormatVersion, Error: err.Error()}
+		dataStart = segmentstore.SegmentHeaderSize
+	case err != nil:
+		return nil, err
+	default:
+		segment.Header = inspectedHeader{Version: header.Version}
+		if header.Version > 0 {
+			segment.Header.SegmentId = header.SegmentId
+			createdAt := header.CreatedAt.UTC()
+			segment.Header.CreatedAt = &createdAt
+		}
+		dataStart = int64(header.DataStart())
+	}
+
+	for frame := range segmentstore.ReadFrames(file, dataStart, fileInfo.Size()) {
+		inspected := inspectedFrame{
+			Offset: frame.Offset,
+			Size:   frame.Size,
+			Crc:    fmt.Sprintf("%08x", frame.Crc),
+			Status: "ok",
+		}
+		switch {
+		case frame.Err == nil:
+			record := inspectRecord(frame.Record)
+			inspected.inspectedRecord = &record
+		case errors.Is(frame.Err, bitcask_errors.ErrIncompleteRecord):
+			inspected.Status = "incomplete"
+		case errors.Is(frame.Err, bitcask_errors.ErrCrcVerificationFailed):
+			inspected.Status = "bad-crc"
+		default:
+			inspected.Status = "unreadable"
+		}
+		if frame.Err != nil {
+			segment.DamagedFrames++
+			inspected.Error = frame.Err.Error()
+			if inspectHexdump {
+				inspected.Hexdump = hexdump(file, frame.Offset, frame.Size)
+			}
+		}
+		segment.Frames = append(segment.Frames, inspected)
+	}
+	return segment, nil
+}
+
+func inspectRecord(record *segmentstore.Record) inspectedRecord {
+	inspected := inspectedRecord{
+		Type:      recordTypeName(record.Type()),
+		Seq:       record.Seq(),
+		Timestamp: record.Timestamp().UTC(),
+		KeySize:   len(record.Key),
+		ValueSize: len(record.Val),
+	}
+	if expiresAt := record.ExpiresAt(); !expiresAt.IsZero() {
+		expiresAt = expiresAt.UTC()
+		inspected.ExpiresAt = &expiresAt
+	}
+	if record.Type() == segmentstore.BatchRecord {
+		for _, batchedRecord := range record.Records() {
+			inspected.Records = append(inspected.Records, inspectRecord(batchedRecord))
+		}
+	}
+	return inspected
+}
+
+func recordTypeName(recordType segmentstore.RecordType) string {
+	switch recordType {
+	case segmentstore.RegularRecord:
+		return "regular"
+	case segmentstore.TombstoneRecord:
+		return "tombstone"
+	case segmentstore.BatchRecord:
+		return "batch"
+	}
+	return fmt.Sprintf("unknown(%d)", recordType)
+}
+
+// hexdump returns the hexdump of size bytes of file from offset, with the offsets
+// in the file, cut short at maxHexdumpSize bytes.
+func hexdump(file io.ReaderAt, offset, size int64) string {
+	buf := make([]byte, min(size, maxHexdumpSize))
+	n, err := file.ReadAt(buf, offset)
+	if err != nil && err != io.EOF {
+		return fmt.Sprintf("can not read frame: %v\n", err)
+	}
+	buf = buf[:n]
+
+	var dump strings.Builder
+	for i := 0; i < len(buf); i += 16 {
+		line := buf[i:min(i+16, len(buf))]
+		printable := bytes.Map(func(r rune) rune {
+			if r < ' ' || r > '~' {
+				return '.'
+			}
+			return r
+		}, line)
+		fmt.Fprintf(&dump, "%08x  %-47s  |%s|\n", offset+int64(i), fmt.Sprintf("% x", line), printable)
+	}
+	if size > int64(len(buf)) {
+		fmt.Fprintf(&dump, "... %d more bytes\n", size-int64(len(buf)))
+	}
+	return dump.String()
+}
+
+func printSegment(out io.Writer, segment *inspectedSegment) error {
+	header := segment.Header
+	switch {
+	case header.Error != "":
+		fmt.Fprintf(out, "%s: %d bytes, %s\n", segment.File, segment.Size, header.Error)
+	case header.Version == 0:
+		fmt.Fprintf(out, "%s: %d bytes, segment without header\n", segment.File, segment.Size)
+	default:
+		fmt.Fprintf(out, "%s: %d bytes, segment %d of version %d created at %s\n", segment.File, segment.Size, header.SegmentId, header.Version, header.CreatedAt.Format(time.RFC3339Nano))
+	}
+
+	writer := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(writer, "OFFSET\tSIZE\tCRC\tSTATUS\tTYPE\tSEQ\tTIMESTAMP\tKEY SIZE\tVALUE SIZE")
+	for _, frame := range segment.Frames {
+		if frame.inspectedRecord == nil {
+			fmt.Fprintf(writer, "%d\t%d\t%s\t%s\t-\t-\t-\t-\t-\n", frame.Offset, frame.Size, frame.Crc, frame.Status)
+			continue
+		}
+
+		record := frame.inspectedRecord
+		fmt.Fprintf(writer, "%d\t%d\t%s\t%s\t%s\t%d\t%s\t%d\t%d\n", frame.Offset, frame.Size, frame.Crc, frame.Status, record.Type, record.Seq, record.Timestamp.Format(time.RFC3339Nano), record.KeySize, record.ValueSize)
+		for _, batchedRecord := range record.Records {
+			fmt.Fprintf(writer, "\t\t\t\t  %s\t\t\t%d\t%d\n", batchedRecord.Type, batchedRecord.KeySize, batchedRecord.ValueSize)
+		}
+	}
+	if err := writer.Flush(); err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "%d frames, %d damaged\n", len(segment.Frames), segment.DamagedFrames)
+
+	for _, frame := range segment.Frames {
+		if frame.Error != "" {
+			fmt.Fprintf(out, "\nframe at offset %d: %s\n%s", frame.Offset, frame.Error, frame.Hexdump)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,367 @@
+// Command bitcask reads and modifies a Bitcask DB from the shell.
+//
+// Usage:
+//
+//	bitcask -dir <data-directory> -db <db-name> [-read-only] <command> [arguments]
+//	bitcask inspect [--json] [--hexdump] <segment-file>
+//
+// The repair command works on the files of the DB, which must not be open.
+//
+// Commands which only read the DB open it read-only, so that any number of them can
+// run at once, even while another process writes to the DB. Commands which write to
+// the DB fail while it is opened for writing by another process.
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/nitin-goyal19/bitcask"
+	"github.com/nitin-goyal19/bitcask/config"
+)
+
+type command struct {
+	name     string
+	args     string
+	help     string
+	readOnly bool // whether the command only reads the DB
+	run      func(db *bitcask.Bitcask, args []string) error
+	// run by commands which do not open a DB in place of run
+	runWithoutDB func(args []string) error
+	// run by commands which work on the files of a DB which is not open in place of run
+	runOnClosedDB func(dbName string, config *config.Config, args []string) error
+	flags         func(flags *flag.FlagSet)
+}
+
+// Flags of the commands
+var (
+	ttl          time.Duration
+	prefix       string
+	repairDryRun bool
+)
+
+var commands = []*command{
+	{
+		name:     "get",
+		args:     "<key>",
+		help:     "print the value of key",
+		readOnly: true,
+		run:      runGet,
+	},
+	{
+		name: "set",
+		args: "[--ttl <duration>] <key> <value>",
+		help: "set key to value, or to the standard input if value is -",
+		run:  runSet,
+		flags: func(flags *flag.FlagSet) {
+			flags.DurationVar(&ttl, "ttl", 0, "time after which the value expires")
+		},
+	},
+	{
+		name: "del",
+		args: "<key>",
+		help: "delete key",
+		run:  runDel,
+	},
+	{
+		name:     "keys",
+		args:     "[--prefix <prefix>]",
+		help:     "print the keys in key order, quoted as Go strings",
+		readOnly: true,
+		run:      runKeys,
+		flags: func(flags *flag.FlagSet) {
+			flags.StringVar(&prefix, "prefix", "", "only print keys starting with prefix")
+		},
+	},
+	{
+		name:     "stats",
+		help:     "print the number of keys and segments and the size of the DB",
+		readOnly: true,
+		run:      runStats,
+	},
+	{
+		name: "merge",
+		help: "compact the DB, dropping overwritten and deleted values",
+		run:  runMerge,
+	},
+	{
+		name:     "verify",
+		help:     "check the CRC of every record of the DB",
+		readOnly: true,
+		run:      runVerify,
+	},
+	{
+		name:     "dump",
+		help:     "print every key and its value in key order, quoted as Go strings and separated by a tab",
+		readOnly: true,
+		run:      runDump,
+	},
+	{
+		name:          "repair",
+		args:          "[--dry-run]",
+		help:          "rebuild a damaged DB out of the records which can still be read, printing the keys whose values were lost",
+		runOnClosedDB: runRepair,
+		flags: func(flags *flag.FlagSet) {
+			flags.BoolVar(&repairDryRun, "dry-run", false, "only report the damage, without changing the DB")
+		},
+	},
+	{
+		name:         "inspect",
+		args:         "[--json] [--hexdump] <segment-file>",
+		help:         "print every frame of a segment file, which does not need -dir and -db",
+		runWithoutDB: runInspect,
+		flags: func(flags *flag.FlagSet) {
+			flags.BoolVar(&inspectJSON, "json", false, "print JSON")
+			flags.BoolVar(&inspectHexdump, "hexdump", false, "print a hexdump of damaged frames")
+		},
+	},
+}
+
+func usage() {
+	out := flag.CommandLine.Output()
+	fmt.Fprintf(out, "Usage: bitcask -dir <data-directory> -db <db-name> [-read-only] <command> [arguments]\n")
+	fmt.Fprintf(out, "       bitcask inspect [--json] [--hexdump] <segment-file>\n\nCommands:\n")
+	for _, cmd := range commands {
+		fmt.Fprintf(out, "  %-8s %s\n", cmd.name, cmd.args)
+		fmt.Fprintf(out, "           %s\n", cmd.help)
+	}
+	fmt.Fprintf(out, "\nFlags:\n")
+	flag.PrintDefaults()
+}
+
+func main() {
+	dataDirectory := flag.String("dir", "", "data directory holding the DB")
+	dbName := flag.String("db", "", "name of the DB in the data directory")
+	readOnly := flag.Bool("read-only", false, "open the DB read-only even for commands which write to it")
+	segmentSize := flag.Int64("segment-size", 0, "size in bytes after which a new segment is started, by default the one the DB was written with, or 1 GB for a new DB")
+	flag.Usage = usage
+	flag.Parse()
+
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	name := flag.Arg(0)
+	idx := slices.IndexFunc(commands, func(cmd *command) bool { return cmd.name == name })
+	if idx < 0 {
+		fmt.Fprintf(os.Stderr, "bitcask: unknown command %q\n", name)
+		flag.Usage()
+		os.Exit(2)
+	}
+	cmd := commands[idx]
+
+	flags := flag.NewFlagSet(cmd.name, flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: bitcask %s %s\n", cmd.name, cmd.args)
+		flags.PrintDefaults()
+	}
+	if cmd.flags != nil {
+		cmd.flags(flags)
+	}
+	flags.Parse(flag.Args()[1:])
+
+	if cmd.runWithoutDB != nil {
+		exit(cmd, flags, cmd.runWithoutDB(flags.Args()))
+		return
+	}
+	if *dataDirectory == "" || *dbName == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	cfg := &config.Config{
+		DataDirectory: *dataDirectory,
+		SegmentSize:   *segmentSize,
+		ReadOnly:      *readOnly || cmd.readOnly,
+	}
+	if cmd.runOnClosedDB != nil {
+		exit(cmd, flags, cmd.runOnClosedDB(*dbName, cfg, flags.Args()))
+		return
+	}
+
+	db, err := bitcask.Open(*dbName, cfg)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "bitcask: opening %s: %v\n", *dbName, err)
+		os.Exit(1)
+	}
+
+	err = cmd.run(db, flags.Args())
+	db.Close()
+	exit(cmd, flags, err)
+}
+
+// exit exits with the status for the error returned by a command.
+func exit(cmd *command, flags *flag.FlagSet, err error) {
+	if errors.Is(err, errUsage) {
+		flags.Usage()
+		os.Exit(2)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "bitcask %s: %v\n", cmd.name, err)
+		os.Exit(1)
+	}
+}
+
+var errUsage = errors.New("invalid arguments")
+
+func runGet(db *bitcask.Bitcask, args []string) error {
+	if len(args) != 1 {
+		return errUsage
+	}
+
+	val, err := db.Get([]byte(args[0]))
+	if err != nil {
+		return err
+	}
+	_, err = os.Stdout.Write(val)
+	return err
+}
+
+func runSet(db *bitcask.Bitcask, args []string) error {
+	if len(args) != 2 {
+		return errUsage
+	}
+
+	val := []byte(args[1])
+	if args[1] == "-" {
+		var err error
+		if val, err = io.ReadAll(os.Stdin); err != nil {
+			return err
+		}
+	}
+
+	if ttl != 0 {
+		return db.SetWithTTL([]byte(args[0]), val, ttl)
+	}
+	return db.Set([]byte(args[0]), val)
+}
+
+func runDel(db *bitcask.Bitcask, args []string) error {
+	if len(args) != 1 {
+		return errUsage
+	}
+
+	_, err := db.Delete([]byte(args[0]))
+	return err
+}
+
+func runKeys(db *bitcask.Bitcask, args []string) error {
+	if len(args) != 0 {
+		return errUsage
+	}
+
+	for _, key := range sortedKeys(db, []byte(prefix)) {
+		if _, err := fmt.Println(strconv.Quote(string(key))); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sortedKeys returns the keys of the DB having prefix in key order.
+func sortedKeys(db *bitcask.Bitcask, prefix []byte) [][]byte {
+	var keys [][]byte
+	for key := range db.Keys() {
+		if bytes.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	slices.SortFunc(keys, bytes.Compare)
+	return keys
+}
+
+func runStats(db *bitcask.Bitcask, args []string) error {
+	if len(args) != 0 {
+		return errUsage
+	}
+
+	stats := db.Stats()
+	fmt.Printf("keys: %d\n", stats.Keys)
+	fmt.Printf("segments: %d\n", stats.Segments)
+	fmt.Printf("size: %d\n", stats.Size)
+	return nil
+}
+
+func runMerge(db *bitcask.Bitcask, args []string) error {
+	if len(args) != 0 {
+		return errUsage
+	}
+
+	return db.Merge()
+}
+
+func runVerify(db *bitcask.Bitcask, args []string) error {
+	if len(args) != 0 {
+		return errUsage
+	}
+
+	if err := db.Verify(); err != nil {
+		return err
+	}
+	fmt.Println("ok")
+	return nil
+}
+
+func runDump(db *bitcask.Bitcask, args []string) error {
+	if len(args) != 0 {
+		return errUsage
+	}
+
+	for _, key := range sortedKeys(db, nil) {
+		val, err := db.Get(key)
+		if err != nil {
+			return fmt.Errorf("reading %s: %w", strconv.Quote(string(key)), err)
+		}
+		if _, err := fmt.Printf("%s\t%s\n", strconv.Quote(string(key)), strconv.Quote(string(val))); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func runRepair(dbName string, cfg *config.Config, args []string) error {
+	if len(args) != 0 {
+		return errUsage
+	}
+
+	cfg.ReadOnly = cfg.ReadOnly || repairDryRun
+	report, err := bitcask.Repair(dbName, cfg)
+	if err != nil {
+		return err
+	}
+
+	if report.ManifestDamaged {
+		fmt.Println("manifest: damaged, rebuilt from the segment files")
+	}
+	for _, segmentId := range report.MissingSegments {
+		fmt.Printf("segment %d: missing\n", segmentId)
+	}
+	for _, damaged := range report.DamagedSegments {
+		fmt.Printf("segment %d: %d damaged regions", damaged.SegmentId, len(damaged.Regions))
+		if damaged.NewSegmentId != 0 {
+			fmt.Printf(", salvaged records copied to segment %d", damaged.NewSegmentId)
+		}
+		fmt.Println()
+		for _, region := range damaged.Regions {
+			fmt.Printf("  offset %d, %d bytes: %v\n", region.Offset, region.Size, region.Err)
+		}
+	}
+	fmt.Printf("segments: %d, damaged: %d\n", report.Segments, len(report.DamagedSegments))
+	fmt.Printf("salvaged records: %d\n", report.SalvagedRecords)
+	if report.QuarantineDir != "" {
+		fmt.Printf("quarantine: %s\n", report.QuarantineDir)
+	}
+	fmt.Printf("lost keys: %d\n", len(report.LostKeys))
+	for _, key := range report.LostKeys {
+		fmt.Println(strconv.Quote(string(key)))
+	}
+	return nil
+}
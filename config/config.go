@@ -3,6 +3,7 @@ package config
 import (
 	"os"
 	"path/filepath"
+	"time"
 
 	bitcask_errors "github.com/nitin-goyal19/bitcask/errors"
 )
@@ -14,11 +15,69 @@ const (
 	GB = 1024 * MB
 )
 
+// SyncPolicy decides when writes to the active segment are flushed to disk with fsync.
+type SyncPolicy int
+
+const (
+	// SyncNever leaves flushing to the operating system. Acknowledged writes can be lost on power loss.
+	SyncNever SyncPolicy = iota
+	// SyncEveryWrite flushes every write before it is acknowledged.
+	SyncEveryWrite
+	// SyncPeriodically flushes in the background every SyncInterval.
+	SyncPeriodically
+	// SyncEveryNBytes flushes once SyncBytes bytes have been written since the last flush.
+	SyncEveryNBytes
+)
+
+// CorruptionPolicy decides what Open does with a corrupt record found in a segment
+// other than at the end of the most recently written one. A corrupt or incomplete
+// record at the end of the most recently written segment is always the result of
+// a crash in the middle of a write, so that segment is cut short before it.
+type CorruptionPolicy int
+
+const (
+	// CorruptionFail makes Open fail.
+	CorruptionFail CorruptionPolicy = iota
+	// CorruptionSkip drops the corrupt record and every record after it in the segment.
+	// The segment file is left untouched till it is merged.
+	CorruptionSkip
+)
+
+// IndexType decides the data structure used for the in-memory index of keys.
+type IndexType int
+
+const (
+	// HashIndex keeps keys in a hash map. Scans and ranges have to sort the keys they return.
+	HashIndex IndexType = iota
+	// OrderedIndex keeps keys in key order, so that scans and ranges only visit the keys they return.
+	OrderedIndex
+)
+
+// Corruption is a corrupt record found by the background scrubber.
+type Corruption struct {
+	SegmentId int64
+	Offset    int64    // offset of the WAL frame of the record in the segment file
+	Keys      [][]byte // keys whose current value is in the corrupt record
+	Err       error
+}
+
 type Config struct {
 	DataDirectory         string
-	SegmentSize           int64
+	SegmentSize           int64 // defaults to the size an existing DB was written with, or to 1 GB for a new DB, and can not be changed once the DB is written
+	SyncPolicy            SyncPolicy
+	SyncInterval          time.Duration // used with SyncPeriodically, defaults to 1 second
+	SyncBytes             int64         // used with SyncEveryNBytes, defaults to 1 MB
+	CorruptionPolicy      CorruptionPolicy
+	IndexType             IndexType
+	ReadOnly              bool             // opens an existing DB for reading only, without writing anything to its directory, even while another process writes to it
+	ScrubInterval         time.Duration    // time between passes of the background scrubber checking the CRCs of the immutable segments, which does not run if 0
+	ScrubRate             int64            // bytes per second read by the scrubber, defaults to 4 MB
+	MarkCorruptKeys       bool             // makes reads of keys whose values the scrubber found corrupt return ErrCorrupted
+	OnCorruption          func(Corruption) // called by the scrubber for every corrupt record it finds
+	VerifyChecksums       bool             // makes reads check the CRC of the whole record holding a value before returning it
 	segmentsDirName       string
 	mergedSegmentsDirName string
+	segmentSizeIsDefault  bool
 }
 
 func (config *Config) Validate() error {
@@ -32,6 +91,7 @@ func (config *Config) Validate() error {
 		return err
 	}
 
+	config.segmentSizeIsDefault = config.SegmentSize == 0
 	if config.SegmentSize == 0 {
 		config.SegmentSize = 1 * GB
 	}
@@ -40,6 +100,44 @@ func (config *Config) Validate() error {
 		return bitcask_errors.ErrInvalidSegmentSize
 	}
 
+	switch config.SyncPolicy {
+	case SyncNever, SyncEveryWrite:
+	case SyncPeriodically:
+		if config.SyncInterval == 0 {
+			config.SyncInterval = 1 * time.Second
+		}
+		if config.SyncInterval < 0 {
+			return bitcask_errors.ErrInvalidSyncInterval
+		}
+	case SyncEveryNBytes:
+		if config.SyncBytes == 0 {
+			config.SyncBytes = 1 * MB
+		}
+		if config.SyncBytes < 0 {
+			return bitcask_errors.ErrInvalidSyncBytes
+		}
+	default:
+		return bitcask_errors.ErrInvalidSyncPolicy
+	}
+
+	if config.CorruptionPolicy != CorruptionFail && config.CorruptionPolicy != CorruptionSkip {
+		return bitcask_errors.ErrInvalidCorruptionPolicy
+	}
+
+	if config.ScrubInterval < 0 {
+		return bitcask_errors.ErrInvalidScrubInterval
+	}
+	if config.ScrubRate == 0 {
+		config.ScrubRate = 4 * MB
+	}
+	if config.ScrubRate < 0 {
+		return bitcask_errors.ErrInvalidScrubRate
+	}
+
+	if config.IndexType != HashIndex && config.IndexType != OrderedIndex {
+		return bitcask_errors.ErrInvalidIndexType
+	}
+
 	config.segmentsDirName = "segments"
 	config.mergedSegmentsDirName = "merged-segments"
 
@@ -53,3 +151,9 @@ func (config *Config) GetSegmentDirName() string {
 func (config *Config) GetMergeSegmentDirName() string {
 	return config.mergedSegmentsDirName
 }
+
+// SegmentSizeIsDefault tells whether SegmentSize was left unset, in which case an
+// existing DB is opened with the SegmentSize it was written with.
+func (config *Config) SegmentSizeIsDefault() bool {
+	return config.segmentSizeIsDefault
+}
@@ -3,9 +3,34 @@ package bitcask_errors
 import "errors"
 
 var (
-	ErrKeyNotFound           = errors.New("key not found")
-	ErrCrcVerificationFailed = errors.New("CRC32 checsum verification failed")
-	ErrInvalidSegmentSize    = errors.New("SegmentSize in config must be a positive integer")
-	ErrInvalidDbName         = errors.New("DB name must be string with length greater than 0")
-	ErrSegmentClosedForWrite = errors.New("segment is closed for writing")
+	ErrKeyNotFound             = errors.New("key not found")
+	ErrCrcVerificationFailed   = errors.New("CRC32 checsum verification failed")
+	ErrInvalidSegmentSize      = errors.New("SegmentSize in config must be a positive integer")
+	ErrInvalidDbName           = errors.New("DB name must be string with length greater than 0 which can be used as a directory name")
+	ErrSegmentClosedForWrite   = errors.New("segment is closed for writing")
+	ErrMergeInProgress         = errors.New("merge is already in progress")
+	ErrDatabaseLocked          = errors.New("DB is already opened by another process or Bitcask instance")
+	ErrDbClosed                = errors.New("DB is closed")
+	ErrIncompleteRecord        = errors.New("segment ends in the middle of a record")
+	ErrInvalidCorruptionPolicy = errors.New("CorruptionPolicy in config is not a known corruption policy")
+	ErrInvalidIndexType        = errors.New("IndexType in config is not a known index type")
+	ErrInvalidSyncPolicy       = errors.New("SyncPolicy in config is not a known sync policy")
+	ErrInvalidSyncInterval     = errors.New("SyncInterval in config must be a positive duration")
+	ErrInvalidSyncBytes        = errors.New("SyncBytes in config must be a positive integer")
+	ErrConflict                = errors.New("transaction conflicts with a write made after it started")
+	ErrTxReadOnly              = errors.New("transaction is read-only")
+	ErrTxClosed                = errors.New("transaction is closed")
+	ErrSnapshotClosed          = errors.New("snapshot is closed")
+	ErrKeyExists               = errors.New("key already exists")
+	ErrValueMismatch           = errors.New("value of key is not the expected value")
+	ErrInvalidTTL              = errors.New("TTL must be a positive duration")
+	ErrInvalidSegmentHeader    = errors.New("segment file has an invalid header")
+	ErrUnknownSegmentVersion   = errors.New("segment file has an unknown format version")
+	ErrMissingSegment          = errors.New("segment listed in the manifest is missing")
+	ErrReadOnly                = errors.New("DB is opened read-only")
+	ErrInvalidScrubInterval    = errors.New("ScrubInterval in config must not be negative")
+	ErrInvalidScrubRate        = errors.New("ScrubRate in config must be a positive integer")
+	ErrCorrupted               = errors.New("value of key is corrupted")
+	ErrLegacyLayout            = errors.New("data directory holds the segments of a DB written before every DB had its own directory")
+	ErrConfigMismatch          = errors.New("DB was written with a configuration other than the one it is opened with")
 )
@@ -0,0 +1,192 @@
+package segmentstore
+
+import (
+	"encoding/binary"
+	"hash/crc32"
+	"runtime"
+
+	"github.com/nitin-goyal19/bitcask/config"
+	bitcask_errors "github.com/nitin-goyal19/bitcask/errors"
+)
+
+// Upper bound on the size of the frames written to a segment by one group commit.
+const maxGroupCommitSize = 4 * config.MB
+
+// writeRequest is a record waiting to be written by the committer, along with
+// the channel on which the writer is told the outcome.
+type writeRequest struct {
+	record *Record
+	frame  []byte
+	// check, if not nil, is called by the committer with mu held right before the
+	// record is written, and the record is not written if it returns an error.
+	check func() error
+	done  chan error
+}
+
+// pendingWrite is a request whose frame has been added to the group being committed.
+type pendingWrite struct {
+	request     *writeRequest
+	frameOffset uint64 // offset of the frame from the start of the group
+}
+
+// StartCommitter starts the goroutine which writes the records of all writers
+// to the active segment. It is stopped by Close.
+func (segStore *SegmentStore) StartCommitter() {
+	segStore.writeRequests = make(chan *writeRequest)
+	segStore.stopCommitter = make(chan struct{})
+	segStore.committerDone = make(chan struct{})
+	go segStore.runCommitter()
+}
+
+// submit hands a record over to the committer and waits till it is durable as
+// per the sync policy of the store.
+func (segStore *SegmentStore) submit(record *Record) error {
+	return segStore.submitIf(record, nil)
+}
+
+// submitIf is submit for a record which is written only if check, called with mu
+// held, returns nil. The index seen by check reflects every write committed before.
+func (segStore *SegmentStore) submitIf(record *Record, check func() error) error {
+	if segStore.config.ReadOnly {
+		return bitcask_errors.ErrReadOnly
+	}
+
+	recordHeaderBuf := GetEncodedRecordHeader(record)
+	// The CRC is filled in by the committer, along with the sequence number
+	walRecordHeaderBuf := make([]byte, WalRecordHeaderSize)
+	binary.BigEndian.PutUint64(walRecordHeaderBuf[4:], record.WriteSize())
+
+	request := &writeRequest{
+		record: record,
+		frame:  appendFrame(make([]byte, 0, WalRecordHeaderSize+record.WriteSize()), walRecordHeaderBuf, recordHeaderBuf, record),
+		check:  check,
+		done:   make(chan error, 1),
+	}
+
+	select {
+	case segStore.writeRequests <- request:
+	case <-segStore.stopCommitter:
+		return bitcask_errors.ErrDbClosed
+	}
+	return <-request.done
+}
+
+// runCommitter collects the requests of all writers waiting at that moment into
+// a group, and commits each group with one write and at most one sync.
+func (segStore *SegmentStore) runCommitter() {
+	defer close(segStore.committerDone)
+
+	for {
+		var group []*writeRequest
+		select {
+		case request := <-segStore.writeRequests:
+			group = append(group, request)
+		case <-segStore.stopCommitter:
+			return
+		}
+
+		// Let writers which are ready to run queue up their records before the group is closed
+		runtime.Gosched()
+
+		groupSize := len(group[0].frame)
+	collect:
+		for groupSize < maxGroupCommitSize {
+			select {
+			case request := <-segStore.writeRequests:
+				group = append(group, request)
+				groupSize += len(request.frame)
+			default:
+				break collect
+			}
+		}
+
+		segStore.commit(group)
+	}
+}
+
+func (segStore *SegmentStore) commit(group []*writeRequest) {
+	segStore.mu.Lock()
+	defer segStore.mu.Unlock()
+
+	var pending []pendingWrite
+	frames := segStore.commitBuf[:0]
+
+	for _, request := range group {
+		if request.check != nil {
+			// The check has to see the writes queued before it in the index
+			segStore.flush(pending, frames)
+			pending, frames = pending[:0], frames[:0]
+
+			if err := request.check(); err != nil {
+				request.done <- err
+				continue
+			}
+		}
+
+		segmentSize := segStore.activeSegment.curSize + int64(len(frames))
+		isEmpty := segStore.activeSegment.isEmpty() && len(frames) == 0
+		if !isEmpty && segmentSize+int64(len(request.frame)) > segStore.config.SegmentSize {
+			segStore.flush(pending, frames)
+			pending, frames = pending[:0], frames[:0]
+
+			if err := segStore.OpenNewSegmentFile(); err != nil {
+				request.done <- err
+				continue
+			}
+		}
+
+		segStore.lastSeq++
+		request.record.setSeq(segStore.lastSeq)
+		sealFrame(request.frame, segStore.lastSeq)
+
+		pending = append(pending, pendingWrite{
+			request:     request,
+			frameOffset: uint64(len(frames)),
+		})
+		frames = append(frames, request.frame...)
+	}
+
+	segStore.flush(pending, frames)
+	if cap(frames) <= 2*maxGroupCommitSize {
+		segStore.commitBuf = frames
+	}
+}
+
+// sealFrame sets the sequence number in the WAL frame of a record, and then its CRC.
+func sealFrame(frame []byte, seq uint64) {
+	binary.BigEndian.PutUint64(frame[frameSeqOffset:], seq)
+	binary.BigEndian.PutUint32(frame, crc32.ChecksumIEEE(frame[4:]))
+}
+
+// flush writes the frames of the pending writes to the active segment, syncs
+// it if the sync policy asks for it, and only then updates the index and
+// releases the writers.
+func (segStore *SegmentStore) flush(pending []pendingWrite, frames []byte) {
+	if len(pending) == 0 {
+		return
+	}
+
+	segment := segStore.activeSegment
+	baseOffset, err := segment.Append(frames)
+	if err == nil {
+		err = segStore.syncAfterWrite(int64(len(frames)))
+	}
+	if err != nil {
+		for _, write := range pending {
+			write.request.done <- err
+		}
+		return
+	}
+
+	for _, write := range pending {
+		for _, entry := range segment.addHints(write.request.record, baseOffset+write.frameOffset) {
+			if entry.recordType == TombstoneRecord {
+				segStore.index.Delete(entry.key)
+				segStore.lastDeleteSeq = entry.seq
+			} else {
+				segStore.index.Set(entry.key, entry.indexRecord(segment.id))
+			}
+		}
+		write.request.done <- nil
+	}
+}
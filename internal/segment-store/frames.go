@@ -0,0 +1,141 @@
+package segmentstore
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"iter"
+
+	bitcask_errors "github.com/nitin-goyal19/bitcask/errors"
+)
+
+// Frame is a WAL frame of a segment file, as read by ReadFrames.
+type Frame struct {
+	Offset int64
+	// Size of the frame including its WAL record header. For an incomplete frame it
+	// is the size of the rest of the file, and for a frame which could not be read 0.
+	Size int64
+	Crc  uint32 // CRC stored in the WAL record header
+	// Err is ErrIncompleteRecord if the file ends in the middle of the frame, and
+	// wraps ErrCrcVerificationFailed if the frame is damaged.
+	Err    error
+	Record *Record // nil if Err is not nil
+}
+
+// ReadFrames returns an iterator over the WAL frames of a segment file of size bytes,
+// from the first frame at dataStart. Damaged frames are skipped using the length in
+// their WAL record header, so the frames after a damaged length are not found. An
+// incomplete frame, or a frame which could not be read, is the last frame yielded.
+func ReadFrames(file io.ReaderAt, dataStart, size int64) iter.Seq[Frame] {
+	return func(yield func(Frame) bool) {
+		for offset := dataStart; offset < size; {
+			frame := readFrame(file, offset, size)
+			// A frame which could not be read has no size to skip it by
+			if !yield(frame) || frame.Size == 0 {
+				return
+			}
+			offset += frame.Size
+		}
+	}
+}
+
+// readFrame reads the WAL frame at offset of a segment file of size bytes.
+func readFrame(file io.ReaderAt, offset, size int64) Frame {
+	frame := Frame{Offset: offset, Size: size - offset}
+	if frame.Size < WalRecordHeaderSize {
+		frame.Err = bitcask_errors.ErrIncompleteRecord
+		return frame
+	}
+
+	walHeader := make([]byte, WalRecordHeaderSize)
+	if _, err := file.ReadAt(walHeader, offset); err != nil {
+		frame.Err, frame.Size = err, 0
+		return frame
+	}
+	frame.Crc = binary.BigEndian.Uint32(walHeader)
+	recordLen := binary.BigEndian.Uint64(walHeader[4:])
+
+	if recordLen > uint64(size-offset-WalRecordHeaderSize) {
+		frame.Err = bitcask_errors.ErrIncompleteRecord
+		return frame
+	}
+	frame.Size = WalRecordHeaderSize + int64(recordLen)
+	if recordLen < RecordHeaderSize {
+		frame.Err = fmt.Errorf("%w: record of %d bytes is shorter than a record header", bitcask_errors.ErrCrcVerificationFailed, recordLen)
+		return frame
+	}
+
+	recordBuf := make([]byte, recordLen)
+	if _, err := file.ReadAt(recordBuf, offset+WalRecordHeaderSize); err != nil {
+		frame.Err, frame.Size = err, 0
+		return frame
+	}
+	crcSum := crc32.Update(crc32.ChecksumIEEE(walHeader[4:]), crc32.IEEETable, recordBuf)
+	if crcSum != frame.Crc {
+		frame.Err = fmt.Errorf("%w: computed CRC is %08x", bitcask_errors.ErrCrcVerificationFailed, crcSum)
+		return frame
+	}
+
+	record, err := GetDecodedRecord(recordBuf)
+	if err != nil {
+		frame.Err = fmt.Errorf("%w: %w", bitcask_errors.ErrCrcVerificationFailed, err)
+		return frame
+	}
+	frame.Record = record
+	return frame
+}
+
+// ResyncFrames is ReadFrames which, rather than skipping a damaged frame by the
+// length in its WAL record header, looks for the next offset at which a frame with
+// a valid CRC starts. The damaged region till then is yielded as a single frame
+// with Err set.
+func ResyncFrames(file io.ReaderAt, dataStart, size int64) iter.Seq[Frame] {
+	return func(yield func(Frame) bool) {
+		for offset := dataStart; offset < size; {
+			frame := readFrame(file, offset, size)
+			if frame.Err != nil && frame.Size > 0 {
+				next := offset + 1
+				for next < size && readFrame(file, next, size).Err != nil {
+					next++
+				}
+				frame.Size = next - offset
+			}
+
+			if !yield(frame) || frame.Size == 0 {
+				return
+			}
+			offset += frame.Size
+		}
+	}
+}
+
+// decodeDamagedRegion decodes the records of the damaged frames in the size bytes
+// of file from offset, without checking their CRCs, going from frame to frame by
+// the lengths in their WAL record headers. It stops at the first frame which does
+// not hold something which decodes as a record.
+func decodeDamagedRegion(file io.ReaderAt, offset, size int64) []*Record {
+	var records []*Record
+	walHeader := make([]byte, WalRecordHeaderSize)
+	for end := offset + size; end-offset >= WalRecordHeaderSize+RecordHeaderSize; {
+		if _, err := file.ReadAt(walHeader, offset); err != nil {
+			break
+		}
+		recordLen := binary.BigEndian.Uint64(walHeader[4:])
+		if recordLen < RecordHeaderSize || recordLen > uint64(end-offset-WalRecordHeaderSize) {
+			break
+		}
+
+		recordBuf := make([]byte, recordLen)
+		if _, err := file.ReadAt(recordBuf, offset+WalRecordHeaderSize); err != nil {
+			break
+		}
+		record, err := GetDecodedRecord(recordBuf)
+		if err != nil {
+			break
+		}
+		records = append(records, record)
+		offset += WalRecordHeaderSize + int64(recordLen)
+	}
+	return records
+}
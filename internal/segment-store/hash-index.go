@@ -0,0 +1,90 @@
+package segmentstore
+
+import (
+	"bytes"
+	"slices"
+	"sync"
+)
+
+// hashIndex is an Index backed by a map. Range sorts the keys in the range on every call.
+type hashIndex struct {
+	indexRecords map[string]*IndexRecord
+	mu           sync.RWMutex
+}
+
+func createHashIndex() *hashIndex {
+	index := &hashIndex{
+		indexRecords: make(map[string]*IndexRecord),
+	}
+
+	return index
+}
+
+func (index *hashIndex) Get(key []byte) *IndexRecord {
+	index.mu.RLock()
+	defer index.mu.RUnlock()
+	indexRec, ok := index.indexRecords[string(key)]
+
+	if !ok {
+		return nil
+	}
+
+	return indexRec
+}
+
+func (index *hashIndex) Set(key []byte, indexRec *IndexRecord) {
+	index.mu.Lock()
+	defer index.mu.Unlock()
+	index.indexRecords[string(key)] = indexRec
+}
+
+func (index *hashIndex) Delete(key []byte) {
+	index.mu.Lock()
+	defer index.mu.Unlock()
+	delete(index.indexRecords, string(key))
+}
+
+func (index *hashIndex) Replace(key []byte, oldRec, newRec *IndexRecord) bool {
+	index.mu.Lock()
+	defer index.mu.Unlock()
+	if index.indexRecords[string(key)] != oldRec {
+		return false
+	}
+	index.indexRecords[string(key)] = newRec
+	return true
+}
+
+func (index *hashIndex) Range(start, end []byte, reverse bool) []IndexEntry {
+	index.mu.RLock()
+	var entries []IndexEntry
+	for key, indexRec := range index.indexRecords {
+		if inRange([]byte(key), start, end) {
+			entries = append(entries, IndexEntry{Key: []byte(key), indexRec: indexRec})
+		}
+	}
+	index.mu.RUnlock()
+
+	slices.SortFunc(entries, func(a, b IndexEntry) int {
+		if reverse {
+			return bytes.Compare(b.Key, a.Key)
+		}
+		return bytes.Compare(a.Key, b.Key)
+	})
+	return entries
+}
+
+func (index *hashIndex) Entries() []IndexEntry {
+	index.mu.RLock()
+	defer index.mu.RUnlock()
+	entries := make([]IndexEntry, 0, len(index.indexRecords))
+	for key, indexRec := range index.indexRecords {
+		entries = append(entries, IndexEntry{Key: []byte(key), indexRec: indexRec})
+	}
+	return entries
+}
+
+func (index *hashIndex) Len() int {
+	index.mu.RLock()
+	defer index.mu.RUnlock()
+	return len(index.indexRecords)
+}
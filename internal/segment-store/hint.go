@@ -0,0 +1,196 @@
+package segmentstore
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+)
+
+const hintFileSuffix = ".hint"
+
+// Version 2 added the expiry time to the entries, and version 3 the sequence number
+const hintFileVersion = 3
+
+var hintFileMagic = []byte("BCHT")
+
+// magic(4 bytes) + version(1 byte) + size of the segment file covered by the hint file(8 bytes)
+const hintFileHeaderSize = 4 + 1 + 8
+
+// recordType(1 byte) + timestamp(8 bytes) + keySize(2 bytes) + valueSize(4 bytes) + valueOffset(8 bytes) + recordOffset(8 bytes) + expiresAt(8 bytes) + seq(8 bytes)
+const hintEntryHeaderSize = 1 + 8 + 2 + 4 + 8 + 8 + 8 + 8
+
+// Size of the entry headers of each hint file version
+var hintEntryHeaderSizes = map[byte]int{
+	1: hintEntryHeaderSize - 16,
+	2: hintEntryHeaderSize - 8,
+	3: hintEntryHeaderSize,
+}
+
+// CRC(4 bytes) of everything before it
+const hintFileFooterSize = 4
+
+var errInvalidHintFile = errors.New("invalid hint file")
+
+// hintEntry holds everything needed to rebuild the index entry of a record
+// without reading the record from the segment file.
+type hintEntry struct {
+	recordType   RecordType
+	timestamp    uint64
+	seq          uint64
+	expiresAt    uint64
+	key          []byte
+	valueSize    uint32
+	valueOffset  SegmentOffset
+	recordOffset SegmentOffset
+}
+
+// appendHintEntries appends the hint entries of the record held by the WAL frame at
+// frameOffset to entries. A BatchRecord has an entry for every record in the batch,
+// all of them having the offset of the batch's frame as their record offset.
+func appendHintEntries(entries []hintEntry, record *Record, frameOffset SegmentOffset) []hintEntry {
+	recordOffset := frameOffset + WalRecordHeaderSize
+	if record.recordType == BatchRecord {
+		recordOffset += record.ValOffset()
+	}
+
+	for _, flatRecord := range record.flatten() {
+		entries = append(entries, hintEntry{
+			recordType:   flatRecord.recordType,
+			timestamp:    flatRecord.timestamp,
+			seq:          flatRecord.seq,
+			expiresAt:    flatRecord.expiresAt,
+			key:          bytes.Clone(flatRecord.Key),
+			valueSize:    uint32(len(flatRecord.Val)),
+			valueOffset:  recordOffset + flatRecord.ValOffset(),
+			recordOffset: frameOffset,
+		})
+		recordOffset += flatRecord.WriteSize()
+	}
+	return entries
+}
+
+func (entry *hintEntry) indexRecord(segmentId SegmentId) *IndexRecord {
+	return &IndexRecord{
+		segmentId:    segmentId,
+		valueSize:    entry.valueSize,
+		valueOffset:  entry.valueOffset,
+		recordOffset: entry.recordOffset,
+		timestamp:    entry.timestamp,
+		seq:          entry.seq,
+		expiresAt:    entry.expiresAt,
+	}
+}
+
+func hintFileName(segmentId SegmentId) string {
+	return segmentFileName(segmentId) + hintFileSuffix
+}
+
+func encodeHintFile(segmentSize int64, entries []hintEntry) []byte {
+	size := hintFileHeaderSize + hintFileFooterSize
+	for _, entry := range entries {
+		size += hintEntryHeaderSize + len(entry.key)
+	}
+
+	buf := make([]byte, 0, size)
+	buf = append(buf, hintFileMagic...)
+	buf = append(buf, hintFileVersion)
+	buf = binary.BigEndian.AppendUint64(buf, uint64(segmentSize))
+	for _, entry := range entries {
+		buf = append(buf, entry.recordType)
+		buf = binary.BigEndian.AppendUint64(buf, entry.timestamp)
+		buf = binary.BigEndian.AppendUint16(buf, uint16(len(entry.key)))
+		buf = binary.BigEndian.AppendUint32(buf, entry.valueSize)
+		buf = binary.BigEndian.AppendUint64(buf, entry.valueOffset)
+		buf = binary.BigEndian.AppendUint64(buf, entry.recordOffset)
+		buf = binary.BigEndian.AppendUint64(buf, entry.expiresAt)
+		buf = binary.BigEndian.AppendUint64(buf, entry.seq)
+		buf = append(buf, entry.key...)
+	}
+	return binary.BigEndian.AppendUint32(buf, crc32.ChecksumIEEE(buf))
+}
+
+func decodeHintFile(buf []byte, segmentSize int64) ([]hintEntry, error) {
+	if len(buf) < hintFileHeaderSize+hintFileFooterSize || !bytes.Equal(buf[:4], hintFileMagic) {
+		return nil, errInvalidHintFile
+	}
+	version := buf[4]
+	entryHeaderSize, ok := hintEntryHeaderSizes[version]
+	if !ok {
+		return nil, fmt.Errorf("%w: unsupported version %d", errInvalidHintFile, version)
+	}
+	body, footer := buf[:len(buf)-hintFileFooterSize], buf[len(buf)-hintFileFooterSize:]
+	if crc32.ChecksumIEEE(body) != binary.BigEndian.Uint32(footer) {
+		return nil, fmt.Errorf("%w: checksum mismatch", errInvalidHintFile)
+	}
+	if coveredSize := binary.BigEndian.Uint64(body[5:]); coveredSize != uint64(segmentSize) {
+		return nil, fmt.Errorf("%w: covers %d bytes of segment of size %d", errInvalidHintFile, coveredSize, segmentSize)
+	}
+
+	var entries []hintEntry
+	index := hintFileHeaderSize
+	for index < len(body) {
+		if len(body)-index < entryHeaderSize {
+			return nil, errInvalidHintFile
+		}
+		entry := hintEntry{recordType: body[index]}
+		entry.timestamp = binary.BigEndian.Uint64(body[index+1:])
+		keySize := int(binary.BigEndian.Uint16(body[index+9:]))
+		entry.valueSize = binary.BigEndian.Uint32(body[index+11:])
+		entry.valueOffset = binary.BigEndian.Uint64(body[index+15:])
+		entry.recordOffset = binary.BigEndian.Uint64(body[index+23:])
+		if version > 1 {
+			entry.expiresAt = binary.BigEndian.Uint64(body[index+31:])
+		}
+		if version > 2 {
+			entry.seq = binary.BigEndian.Uint64(body[index+39:])
+		}
+		index += entryHeaderSize
+		if len(body)-index < keySize {
+			return nil, errInvalidHintFile
+		}
+		entry.key = body[index : index+keySize]
+		index += keySize
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// writeHintFile atomically replaces the hint file of the segment with one holding entries.
+func writeHintFile(dirPath string, segmentId SegmentId, segmentSize int64, entries []hintEntry) error {
+	hintFilePath := filepath.Join(dirPath, hintFileName(segmentId))
+	tmpFilePath := hintFilePath + ".tmp"
+
+	file, err := os.Create(tmpFilePath)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(encodeHintFile(segmentSize, entries)); err != nil {
+		file.Close()
+		os.Remove(tmpFilePath)
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		os.Remove(tmpFilePath)
+		return err
+	}
+	if err := file.Close(); err != nil {
+		os.Remove(tmpFilePath)
+		return err
+	}
+	return os.Rename(tmpFilePath, hintFilePath)
+}
+
+// readHintFile returns the entries of the hint file of a segment if the hint file
+// exists and describes the segment as it is on disk.
+func readHintFile(dirPath string, segmentId SegmentId, segmentSize int64) ([]hintEntry, error) {
+	buf, err := os.ReadFile(filepath.Join(dirPath, hintFileName(segmentId)))
+	if err != nil {
+		return nil, err
+	}
+	return decodeHintFile(buf, segmentSize)
+}
@@ -1,7 +1,11 @@
 package segmentstore
 
 import (
-	"sync"
+	"bytes"
+	"slices"
+	"time"
+
+	"github.com/nitin-goyal19/bitcask/config"
 )
 
 type IndexRecord struct {
@@ -10,51 +14,109 @@ type IndexRecord struct {
 	valueOffset  SegmentOffset
 	recordOffset SegmentOffset //Offset of log in segment file (includes segment headers, crc, record), TODO: rename
 	timestamp    uint64
+	seq          uint64
+	expiresAt    uint64 // unix time in nanoseconds after which the value is expired, 0 if it never is
 }
 
-type Index struct {
-	indexRecords map[string]*IndexRecord
-	mu           sync.RWMutex
+// getLive returns the index record of key, or nil if the key is not in the index
+// or its value has expired.
+func getLive(index Index, key []byte) *IndexRecord {
+	indexRec := index.Get(key)
+	if indexRec == nil || indexRec.expired(uint64(time.Now().UnixNano())) {
+		return nil
+	}
+	return indexRec
 }
 
-func CreateIndex() *Index {
-	index := &Index{
-		indexRecords: make(map[string]*IndexRecord),
-	}
+// liveEntries removes the entries of expired values from entries.
+func liveEntries(entries []IndexEntry) []IndexEntry {
+	now := uint64(time.Now().UnixNano())
+	return slices.DeleteFunc(entries, func(entry IndexEntry) bool {
+		return entry.indexRec.expired(now)
+	})
+}
 
-	return index
+// version returns the sequence number of the record plus one, so that it is never 0,
+// which stands for a missing key, even for records without a sequence number.
+func (indexRec *IndexRecord) version() uint64 {
+	return indexRec.seq + 1
 }
 
-func (index *Index) Get(key []byte) *IndexRecord {
-	index.mu.RLock()
-	defer index.mu.RUnlock()
-	indexRec, ok := index.indexRecords[string(key)]
+// expired tells whether the value has expired at now, in unix nanoseconds.
+func (indexRec *IndexRecord) expired(now uint64) bool {
+	return indexRec.expiresAt != 0 && indexRec.expiresAt <= now
+}
 
-	if !ok {
-		return nil
-	}
+// IndexEntry is a key along with the index record it pointed to when the entry was taken.
+type IndexEntry struct {
+	Key      []byte
+	indexRec *IndexRecord
+}
 
-	return indexRec
+// Index maps every key in the store to the index record of its latest value.
+// Implementations are safe for concurrent use.
+type Index interface {
+	Get(key []byte) *IndexRecord
+	Set(key []byte, indexRec *IndexRecord)
+	Delete(key []byte)
+	// Replace points key to newRec only if it is still pointing to oldRec.
+	Replace(key []byte, oldRec, newRec *IndexRecord) bool
+	// Range returns the entries of keys in [start, end) in key order, or in reverse
+	// key order if reverse is set. A nil start or end leaves that side of the range open.
+	Range(start, end []byte, reverse bool) []IndexEntry
+	// Entries returns the entries of all keys in the index, in no particular order.
+	Entries() []IndexEntry
+	Len() int
 }
 
-func (index *Index) Set(key []byte, indexRec *IndexRecord) {
-	index.mu.Lock()
-	defer index.mu.Unlock()
-	index.indexRecords[string(key)] = indexRec
+func CreateIndex(indexType config.IndexType) Index {
+	if indexType == config.OrderedIndex {
+		return createOrderedIndex()
+	}
+	return createHashIndex()
 }
 
-func (index *Index) Delete(key []byte) {
-	index.mu.Lock()
-	defer index.mu.Unlock()
-	delete(index.indexRecords, string(key))
+// recordVersion orders the records of a key. Records are ordered by sequence number,
+// and records without one, which predate sequence numbers, by timestamp.
+type recordVersion struct {
+	seq       uint64
+	timestamp uint64
+}
+
+func (version recordVersion) olderThan(other recordVersion) bool {
+	if version.seq != other.seq {
+		return version.seq < other.seq
+	}
+	return version.timestamp < other.timestamp
 }
 
-func (index *Index) CompareTimestamp(key []byte, timestamp uint64) bool {
+// isLatestVersion reports whether a record of key with version is at least as recent
+// as the one the index points to.
+func isLatestVersion(index Index, key []byte, version recordVersion) bool {
 	indexRec := index.Get(key)
 
 	if indexRec == nil {
 		return true
 	}
 
-	return timestamp >= indexRec.timestamp
+	return !version.olderThan(recordVersion{seq: indexRec.seq, timestamp: indexRec.timestamp})
+}
+
+// inRange reports whether key lies in [start, end), where a nil start or end leaves
+// that side of the range open.
+func inRange(key, start, end []byte) bool {
+	return (start == nil || bytes.Compare(key, start) >= 0) && (end == nil || bytes.Compare(key, end) < 0)
+}
+
+// PrefixEnd returns the smallest key greater than every key having prefix, or nil if
+// there is no such key.
+func PrefixEnd(prefix []byte) []byte {
+	end := bytes.Clone(prefix)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] < 0xff {
+			end[i]++
+			return end[:i+1]
+		}
+	}
+	return nil
 }
@@ -0,0 +1,373 @@
+package segmentstore
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/nitin-goyal19/bitcask/config"
+	bitcask_errors "github.com/nitin-goyal19/bitcask/errors"
+	"github.com/nitin-goyal19/bitcask/internal/utils"
+)
+
+// Name of the file in the directory of the DB holding the metadata of the store.
+const manifestFileName = "MANIFEST"
+
+// Version 1 of the manifest only held the next segment id. The segments of a store
+// with such a manifest are the segment files found in the segments directory.
+const manifestVersion = 2
+
+var errInvalidManifest = errors.New("invalid manifest")
+
+// manifest is the metadata of the store which is not held by the segment files. It
+// is written to the MANIFEST file as "name value" lines, the last of which is the
+// CRC of the lines before it, and is replaced atomically on every update.
+//
+// The segments listed in the manifest are the segments of the store. Segment files
+// which are not listed in it are left behind by a rotation or a merge which did not
+// finish, and are deleted when the store is opened.
+//
+// The active segment is recorded whenever it changes, as it is the only segment
+// which can end with records written partially when the store went down.
+type manifest struct {
+	path              string
+	version           int
+	nextSegmentId     SegmentId
+	nextSeq           uint64
+	segmentIds        []SegmentId
+	activeSegmentId   SegmentId // -1 if the manifest was written before it recorded the active segment
+	segmentSize       int64     // 0 if the manifest was written before it recorded the segment size
+	configFingerprint string
+}
+
+func (m *manifest) encode() []byte {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "version %d\n", manifestVersion)
+	fmt.Fprintf(&buf, "segment-format-version %d\n", SegmentFormatVersion)
+	fmt.Fprintf(&buf, "config %s\n", m.configFingerprint)
+	fmt.Fprintf(&buf, "next-segment-id %d\n", m.nextSegmentId)
+	fmt.Fprintf(&buf, "next-seq %d\n", m.nextSeq)
+	buf.WriteString("segments")
+	for _, segmentId := range m.segmentIds {
+		fmt.Fprintf(&buf, " %d", segmentId)
+	}
+	buf.WriteString("\n")
+	if m.activeSegmentId >= 0 {
+		fmt.Fprintf(&buf, "active-segment %d\n", m.activeSegmentId)
+	}
+	if m.segmentSize > 0 {
+		fmt.Fprintf(&buf, "segment-size %d\n", m.segmentSize)
+	}
+	fmt.Fprintf(&buf, "crc32 %08x\n", crc32.ChecksumIEEE(buf.Bytes()))
+	return buf.Bytes()
+}
+
+// readManifest reads the manifest at path. It returns an error satisfying
+// os.IsNotExist if there is no manifest.
+func readManifest(path string) (*manifest, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	crcLineStart := bytes.LastIndex(bytes.TrimSuffix(content, []byte("\n")), []byte("\n")) + 1
+	var storedCrc uint32
+	if _, err := fmt.Sscanf(string(content[crcLineStart:]), "crc32 %x\n", &storedCrc); err != nil {
+		return nil, fmt.Errorf("%w: %w", errInvalidManifest, err)
+	}
+	if crc32.ChecksumIEEE(content[:crcLineStart]) != storedCrc {
+		return nil, fmt.Errorf("%w: checksum mismatch", errInvalidManifest)
+	}
+
+	fields := make(map[string][]string)
+	for _, line := range strings.Split(strings.TrimSpace(string(content[:crcLineStart])), "\n") {
+		values := strings.Fields(line)
+		if len(values) == 0 {
+			return nil, fmt.Errorf("%w: malformed line %q", errInvalidManifest, line)
+		}
+		fields[values[0]] = values[1:]
+	}
+	field := func(name string) string {
+		return strings.Join(fields[name], " ")
+	}
+
+	version, err := strconv.Atoi(field("version"))
+	if err != nil || version < 1 || version > manifestVersion {
+		return nil, fmt.Errorf("%w: unsupported version %q", errInvalidManifest, field("version"))
+	}
+	m := &manifest{path: path, version: version, activeSegmentId: -1}
+
+	if m.nextSegmentId, err = strconv.ParseInt(field("next-segment-id"), 10, 64); err != nil {
+		return nil, fmt.Errorf("%w: next-segment-id: %w", errInvalidManifest, err)
+	}
+	if version == 1 {
+		return m, nil
+	}
+
+	if formatVersion, err := strconv.Atoi(field("segment-format-version")); err != nil || formatVersion > SegmentFormatVersion {
+		return nil, fmt.Errorf("%w: segment-format-version %q", bitcask_errors.ErrUnknownSegmentVersion, field("segment-format-version"))
+	}
+	if m.nextSeq, err = strconv.ParseUint(field("next-seq"), 10, 64); err != nil {
+		return nil, fmt.Errorf("%w: next-seq: %w", errInvalidManifest, err)
+	}
+	if _, ok := fields["segments"]; !ok {
+		return nil, fmt.Errorf("%w: segments are missing", errInvalidManifest)
+	}
+	for _, value := range fields["segments"] {
+		segmentId, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%w: segments: %w", errInvalidManifest, err)
+		}
+		m.segmentIds = append(m.segmentIds, segmentId)
+	}
+	if _, ok := fields["active-segment"]; ok {
+		if m.activeSegmentId, err = strconv.ParseInt(field("active-segment"), 10, 64); err != nil {
+			return nil, fmt.Errorf("%w: active-segment: %w", errInvalidManifest, err)
+		}
+	}
+	if _, ok := fields["segment-size"]; ok {
+		if m.segmentSize, err = strconv.ParseInt(field("segment-size"), 10, 64); err != nil {
+			return nil, fmt.Errorf("%w: segment-size: %w", errInvalidManifest, err)
+		}
+	}
+	m.configFingerprint = field("config")
+	return m, nil
+}
+
+// save atomically replaces the manifest file with the manifest.
+func (m *manifest) save() error {
+	tmpFilePath := m.path + ".tmp"
+
+	file, err := os.Create(tmpFilePath)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(m.encode()); err != nil {
+		file.Close()
+		os.Remove(tmpFilePath)
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		os.Remove(tmpFilePath)
+		return err
+	}
+	if err := file.Close(); err != nil {
+		os.Remove(tmpFilePath)
+		return err
+	}
+	if err := os.Rename(tmpFilePath, m.path); err != nil {
+		return err
+	}
+	m.version = manifestVersion
+	return utils.SyncDir(filepath.Dir(m.path))
+}
+
+// configFingerprint identifies the options of cfg which shape the files of the
+// store, so that opening a store with options other than those it was written
+// with can be told apart.
+func configFingerprint(cfg *config.Config) string {
+	return fmt.Sprintf("%08x", crc32.ChecksumIEEE(fmt.Appendf(nil, "segment-size=%d", cfg.SegmentSize)))
+}
+
+// checkConfig fails with ErrConfigMismatch if the store is opened with options other
+// than those recorded in m. A SegmentSize left unset is taken from m.
+func (segStore *SegmentStore) checkConfig(m *manifest) error {
+	if m.segmentSize > 0 && segStore.config.SegmentSizeIsDefault() {
+		segStore.config.SegmentSize = m.segmentSize
+	}
+	if m.configFingerprint == "" || m.configFingerprint == configFingerprint(segStore.config) {
+		return nil
+	}
+	if m.segmentSize > 0 {
+		return fmt.Errorf("%w: SegmentSize is %d, DB was written with %d", bitcask_errors.ErrConfigMismatch, segStore.config.SegmentSize, m.segmentSize)
+	}
+	return bitcask_errors.ErrConfigMismatch
+}
+
+// loadManifest reads the manifest of the store and returns the ids of the segments
+// of the store in increasing order. Unless the store is opened read-only, the
+// manifest is created if the store does not have one yet, or upgraded if it was
+// written by an older version of the store.
+func (segStore *SegmentStore) loadManifest() ([]SegmentId, error) {
+	path := filepath.Join(segStore.dirPath, manifestFileName)
+	m, err := readManifest(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if m != nil {
+		if err := segStore.checkConfig(m); err != nil {
+			return nil, err
+		}
+	}
+
+	readOnly := segStore.config.ReadOnly
+	var segmentIds []SegmentId
+	if m != nil && m.version == manifestVersion {
+		segmentIds = slices.Sorted(slices.Values(m.segmentIds))
+		// Stray files are left alone when the store is opened read-only, as they are never read
+		if !readOnly {
+			// Segments of a merge are only listed once all of them are in place
+			if err := clearDir(segStore.mergeDirPath()); err != nil {
+				return nil, err
+			}
+			unlock, err := segStore.lockOutReaders()
+			if err != nil {
+				return nil, err
+			}
+			// Segments which were listed before may still be read by read-only opens
+			var keepBelow SegmentId
+			if unlock == nil {
+				keepBelow = m.nextSegmentId
+			} else {
+				defer unlock()
+			}
+			if err := removeUnlistedFiles(segStore.segmentDirPath(), segmentIds, keepBelow); err != nil {
+				return nil, err
+			}
+		}
+	} else {
+		if readOnly {
+			if _, err := os.Stat(filepath.Join(segStore.mergeDirPath(), mergeFinishedFileName)); err == nil {
+				return nil, fmt.Errorf("%w: DB has a merge to be finished by opening it for writing", bitcask_errors.ErrReadOnly)
+			}
+		} else if err := segStore.recoverMerge(); err != nil {
+			return nil, err
+		}
+		if segmentIds, err = listSegmentFiles(segStore.segmentDirPath()); err != nil {
+			return nil, err
+		}
+		if !readOnly {
+			if err := removeUnlistedFiles(segStore.segmentDirPath(), segmentIds, 0); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	var maxSegmentId SegmentId
+	if len(segmentIds) > 0 {
+		maxSegmentId = segmentIds[len(segmentIds)-1]
+	}
+	if m == nil {
+		// The store is new, or was written before it had a manifest
+		m = &manifest{path: path, nextSegmentId: maxSegmentId + 1, activeSegmentId: -1}
+	} else if m.nextSegmentId <= maxSegmentId {
+		return nil, fmt.Errorf("%w: next-segment-id %d is not greater than the id of segment %d", errInvalidManifest, m.nextSegmentId, maxSegmentId)
+	}
+
+	fingerprint := configFingerprint(segStore.config)
+	if !readOnly && (m.version != manifestVersion || m.configFingerprint != fingerprint || m.segmentSize != segStore.config.SegmentSize) {
+		m.segmentIds = segmentIds
+		m.configFingerprint = fingerprint
+		m.segmentSize = segStore.config.SegmentSize
+		if err := m.save(); err != nil {
+			return nil, err
+		}
+	}
+
+	segStore.manifest = m
+	return segmentIds, nil
+}
+
+// saveManifest records the current segments of the store, its active segment and its
+// last sequence number in the manifest. It must be called with mu held.
+func (segStore *SegmentStore) saveManifest() error {
+	segmentIds := make([]SegmentId, 0, len(segStore.oldSegments)+1)
+	for segmentId := range segStore.oldSegments {
+		segmentIds = append(segmentIds, segmentId)
+	}
+	if segStore.activeSegment != nil {
+		segmentIds = append(segmentIds, segStore.activeSegment.id)
+		segStore.manifest.activeSegmentId = segStore.activeSegment.id
+	}
+	slices.Sort(segmentIds)
+
+	segStore.manifest.segmentIds = segmentIds
+	segStore.manifest.nextSeq = segStore.lastSeq + 1
+	return segStore.manifest.save()
+}
+
+// parseSegmentFileName returns the id of the segment a file in the segments
+// directory belongs to, and whether it is a segment file rather than a hint,
+// temporary or retired file of the segment.
+func parseSegmentFileName(name string) (SegmentId, bool, error) {
+	base := name
+	for _, suffix := range []string{hintFileSuffix, ".tmp", retiredFileSuffix} {
+		base = strings.TrimSuffix(base, suffix)
+	}
+	segmentId, err := strconv.ParseInt(base, 10, 64)
+	if err != nil {
+		return 0, false, err
+	}
+	return segmentId, base == name, nil
+}
+
+// listSegmentFiles returns the ids of the segment files in dirPath in increasing
+// order, for a store whose manifest does not list its segments.
+func listSegmentFiles(dirPath string) ([]SegmentId, error) {
+	files, err := os.ReadDir(dirPath)
+	if err != nil {
+		return nil, err
+	}
+
+	var segmentIds []SegmentId
+	for _, file := range files {
+		segmentId, isSegmentFile, err := parseSegmentFileName(file.Name())
+		if err != nil {
+			log.Printf("Ignoring file %s which does not belong to a segment", file.Name())
+			continue
+		}
+		if isSegmentFile {
+			segmentIds = append(segmentIds, segmentId)
+		}
+	}
+	slices.Sort(segmentIds)
+	return segmentIds, nil
+}
+
+// removeUnlistedFiles deletes the files in dirPath which belong to segments not
+// listed in the manifest, along with temporary files and the files of segments
+// retired by a merge, and checks that every listed segment has a segment file.
+// The segment and hint files of unlisted segments with ids below keepBelow are kept.
+func removeUnlistedFiles(dirPath string, segmentIds []SegmentId, keepBelow SegmentId) error {
+	files, err := os.ReadDir(dirPath)
+	if err != nil {
+		return err
+	}
+
+	found := make(map[SegmentId]bool, len(segmentIds))
+	for _, segmentId := range segmentIds {
+		found[segmentId] = false
+	}
+	for _, file := range files {
+		segmentId, isSegmentFile, err := parseSegmentFileName(file.Name())
+		if err != nil {
+			log.Printf("Ignoring file %s which does not belong to a segment", file.Name())
+			continue
+		}
+		_, isListed := found[segmentId]
+		if (isListed || segmentId < keepBelow) && !strings.HasSuffix(file.Name(), ".tmp") && !strings.HasSuffix(file.Name(), retiredFileSuffix) {
+			if isListed && isSegmentFile {
+				found[segmentId] = true
+			}
+			continue
+		}
+		if err := os.Remove(filepath.Join(dirPath, file.Name())); err != nil {
+			return err
+		}
+	}
+
+	for segmentId, ok := range found {
+		if !ok {
+			return fmt.Errorf("%w: segment %d", bitcask_errors.ErrMissingSegment, segmentId)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,302 @@
+package segmentstore
+
+import (
+	"cmp"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	bitcask_errors "github.com/nitin-goyal19/bitcask/errors"
+)
+
+// Name of the file written to the merge directory by versions of the store whose
+// manifest did not list the segments, once all merged segments were durable. It
+// lists the ids of the segments replaced by the merge.
+const mergeFinishedFileName = "MERGE-FINISHED"
+
+type relocatedRecord struct {
+	key    []byte
+	oldRec *IndexRecord
+	newRec *IndexRecord // nil if the record was dropped as it has expired
+}
+
+// Merge rewrites all immutable segments into new segments holding only the
+// records the index still points at, and then replaces the old segments with
+// them. Reads and writes are served while the merge is running.
+func (segStore *SegmentStore) Merge() error {
+	if segStore.config.ReadOnly {
+		return bitcask_errors.ErrReadOnly
+	}
+	if !segStore.isMerging.CompareAndSwap(false, true) {
+		return bitcask_errors.ErrMergeInProgress
+	}
+	defer segStore.isMerging.Store(false)
+
+	// Seal the active segment so that everything written till now takes part in the merge
+	segStore.mu.Lock()
+	if !segStore.activeSegment.isEmpty() {
+		if err := segStore.OpenNewSegmentFile(); err != nil {
+			segStore.mu.Unlock()
+			return err
+		}
+	}
+	mergeSegments := make([]*Segment, 0, len(segStore.oldSegments))
+	for _, segment := range segStore.oldSegments {
+		mergeSegments = append(mergeSegments, segment)
+	}
+	segStore.mu.Unlock()
+
+	// Hint files of the segments being merged must be in place before they are deleted
+	segStore.hintWriters.Wait()
+
+	if len(mergeSegments) == 0 {
+		return nil
+	}
+	slices.SortFunc(mergeSegments, func(a, b *Segment) int {
+		return cmp.Compare(a.id, b.id)
+	})
+
+	if err := clearDir(segStore.mergeDirPath()); err != nil {
+		return err
+	}
+
+	mergedSegments, relocatedRecords, err := segStore.copyLiveRecords(mergeSegments)
+	if err != nil {
+		for _, segment := range mergedSegments {
+			segment.Close()
+		}
+		clearDir(segStore.mergeDirPath())
+		return err
+	}
+
+	segStore.mu.Lock()
+	defer segStore.mu.Unlock()
+
+	// The merge takes effect once the manifest lists the merged segments in place of
+	// the segments they replace. Merged segment files moved into the segments directory
+	// before then are dropped as stray files if the store goes down.
+	for _, segment := range mergedSegments {
+		if err := os.Rename(filepath.Join(segStore.mergeDirPath(), hintFileName(segment.id)), filepath.Join(segStore.segmentDirPath(), hintFileName(segment.id))); err != nil {
+			return err
+		}
+		if err := os.Rename(filepath.Join(segStore.mergeDirPath(), segmentFileName(segment.id)), filepath.Join(segStore.segmentDirPath(), segmentFileName(segment.id))); err != nil {
+			return err
+		}
+	}
+	for _, segment := range mergedSegments {
+		segStore.oldSegments[segment.id] = segment
+	}
+	for _, segment := range mergeSegments {
+		delete(segStore.oldSegments, segment.id)
+	}
+	if err := segStore.saveManifest(); err != nil {
+		for _, segment := range mergeSegments {
+			segStore.oldSegments[segment.id] = segment
+		}
+		for _, segment := range mergedSegments {
+			delete(segStore.oldSegments, segment.id)
+			segment.Close()
+		}
+		return err
+	}
+
+	for _, relocated := range relocatedRecords {
+		if relocated.newRec == nil {
+			// Writers hold mu too, so the key can not change in between
+			if segStore.index.Get(relocated.key) == relocated.oldRec {
+				segStore.index.Delete(relocated.key)
+			}
+			continue
+		}
+		segStore.index.Replace(relocated.key, relocated.oldRec, relocated.newRec)
+	}
+
+	for _, segment := range mergeSegments {
+		if err := segStore.retireSegment(segment); err != nil {
+			return err
+		}
+	}
+	return segStore.removeMergedAwaySegments()
+}
+
+// copyLiveRecords writes the records of segments which are still referenced by the
+// index into new segments in the merge directory.
+func (segStore *SegmentStore) copyLiveRecords(segments []*Segment) ([]*Segment, []relocatedRecord, error) {
+	var mergedSegments []*Segment
+	var relocatedRecords []relocatedRecord
+	var mergedSegment *Segment
+
+	for _, segment := range segments {
+		offset := segment.dataStart
+		for {
+			recordBuf, _, numBytesRead, err := segment.ReadEncodeRecordWithCrcCheck(offset)
+			if err != nil {
+				return mergedSegments, nil, err
+			}
+			if numBytesRead == 0 {
+				break
+			}
+
+			record, err := GetDecodedRecord(recordBuf)
+			if err != nil {
+				return mergedSegments, nil, err
+			}
+
+			// Records of a batch share the offset of the batch's frame, so they are
+			// told apart by the offsets of their values
+			entries := appendHintEntries(nil, record, offset)
+			for i, record := range record.flatten() {
+				indexRec := segStore.index.Get(record.Key)
+				if record.recordType != RegularRecord || indexRec == nil || indexRec.segmentId != segment.id || indexRec.valueOffset != entries[i].valueOffset {
+					continue
+				}
+
+				// Expired values are dropped, along with their keys
+				if indexRec.expired(uint64(time.Now().UnixNano())) {
+					relocatedRecords = append(relocatedRecords, relocatedRecord{key: record.Key, oldRec: indexRec})
+					continue
+				}
+
+				// Records copied out of a batch need a sequence number of their own
+				record.hasSeq = true
+				recordHeaderBuf := GetEncodedRecordHeader(record)
+				walRecordHeaderBuf := GetWalRecordHeader(recordHeaderBuf, record)
+
+				if mergedSegment == nil || record.WriteSize()+uint64(len(walRecordHeaderBuf)) > uint64(segStore.config.SegmentSize-mergedSegment.curSize) {
+					if mergedSegment != nil {
+						if err := segStore.sealMergedSegment(mergedSegment); err != nil {
+							return mergedSegments, nil, err
+						}
+					}
+					segStore.mu.Lock()
+					segmentId, err := segStore.nextSegmentId()
+					segStore.mu.Unlock()
+					if err != nil {
+						return mergedSegments, nil, err
+					}
+
+					mergedSegment, err = CreateNewSegment(segStore.mergeDirPath(), segmentId)
+					if err != nil {
+						return mergedSegments, nil, err
+					}
+					mergedSegments = append(mergedSegments, mergedSegment)
+				}
+
+				valOffset, recordOffset, err := mergedSegment.Write(walRecordHeaderBuf, recordHeaderBuf, record)
+				if err != nil {
+					return mergedSegments, nil, err
+				}
+
+				relocatedRecords = append(relocatedRecords, relocatedRecord{
+					key:    record.Key,
+					oldRec: indexRec,
+					newRec: &IndexRecord{
+						segmentId:    mergedSegment.id,
+						valueSize:    indexRec.valueSize,
+						valueOffset:  valOffset,
+						recordOffset: recordOffset,
+						timestamp:    indexRec.timestamp,
+						seq:          indexRec.seq,
+						expiresAt:    indexRec.expiresAt,
+					},
+				})
+			}
+			offset += numBytesRead
+		}
+	}
+
+	if mergedSegment != nil {
+		if err := segStore.sealMergedSegment(mergedSegment); err != nil {
+			return mergedSegments, nil, err
+		}
+	}
+
+	return mergedSegments, relocatedRecords, nil
+}
+
+func (segStore *SegmentStore) sealMergedSegment(segment *Segment) error {
+	if err := segment.seal(); err != nil {
+		return err
+	}
+	return segment.writeHintFile(segStore.mergeDirPath())
+}
+
+// removeSegmentFiles deletes the segment file and the hint file of a segment.
+func removeSegmentFiles(dirPath string, segmentId SegmentId) error {
+	if err := os.Remove(filepath.Join(dirPath, hintFileName(segmentId))); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err := os.Remove(filepath.Join(dirPath, segmentFileName(segmentId))); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// recoverMerge finishes a merge which was interrupted after all of its segments
+// were written, and throws away the output of any other incomplete merge, for a
+// store whose manifest does not list its segments.
+func (segStore *SegmentStore) recoverMerge() error {
+	mergeDirPath := segStore.mergeDirPath()
+	mergedSegmentIds, err := readMergeFinishedFile(mergeDirPath)
+	if os.IsNotExist(err) {
+		return clearDir(mergeDirPath)
+	}
+	if err != nil {
+		return err
+	}
+
+	for _, segmentId := range mergedSegmentIds {
+		if err := removeSegmentFiles(segStore.segmentDirPath(), segmentId); err != nil {
+			return err
+		}
+	}
+
+	entries, err := os.ReadDir(mergeDirPath)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if entry.Name() == mergeFinishedFileName {
+			continue
+		}
+		if err := os.Rename(filepath.Join(mergeDirPath, entry.Name()), filepath.Join(segStore.segmentDirPath(), entry.Name())); err != nil {
+			return err
+		}
+	}
+
+	return os.Remove(filepath.Join(mergeDirPath, mergeFinishedFileName))
+}
+
+func readMergeFinishedFile(dirPath string) ([]SegmentId, error) {
+	content, err := os.ReadFile(filepath.Join(dirPath, mergeFinishedFileName))
+	if err != nil {
+		return nil, err
+	}
+
+	var segmentIds []SegmentId
+	for _, line := range strings.Fields(string(content)) {
+		segmentId, err := strconv.ParseInt(line, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid segment id %q in %s: %w", line, mergeFinishedFileName, err)
+		}
+		segmentIds = append(segmentIds, segmentId)
+	}
+	return segmentIds, nil
+}
+
+func clearDir(dirPath string) error {
+	entries, err := os.ReadDir(dirPath)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if err := os.RemoveAll(filepath.Join(dirPath, entry.Name())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
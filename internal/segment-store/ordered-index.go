@@ -0,0 +1,159 @@
+package segmentstore
+
+import (
+	"bytes"
+	"math/rand/v2"
+	"slices"
+	"sync"
+)
+
+const (
+	maxSkipListLevel = 32
+	// Probability of a node being present in the next level of the skip list is 1/skipListBranching
+	skipListBranching = 4
+)
+
+type skipListNode struct {
+	key      []byte
+	indexRec *IndexRecord
+	next     []*skipListNode
+}
+
+// orderedIndex is an Index backed by a skip list, which keeps keys in order so that
+// ranges of keys can be read without looking at the rest of the index.
+type orderedIndex struct {
+	head   *skipListNode
+	level  int
+	length int
+	mu     sync.RWMutex
+}
+
+func createOrderedIndex() *orderedIndex {
+	return &orderedIndex{
+		head:  &skipListNode{next: make([]*skipListNode, maxSkipListLevel)},
+		level: 1,
+	}
+}
+
+func randomSkipListLevel() int {
+	level := 1
+	for level < maxSkipListLevel && rand.IntN(skipListBranching) == 0 {
+		level++
+	}
+	return level
+}
+
+// findGreaterOrEqual returns the first node with a key not less than key. If prev is
+// not nil, it is filled with the last node before key on every level.
+func (index *orderedIndex) findGreaterOrEqual(key []byte, prev []*skipListNode) *skipListNode {
+	node := index.head
+	for level := index.level - 1; level >= 0; level-- {
+		for node.next[level] != nil && bytes.Compare(node.next[level].key, key) < 0 {
+			node = node.next[level]
+		}
+		if prev != nil {
+			prev[level] = node
+		}
+	}
+	return node.next[0]
+}
+
+func (index *orderedIndex) Get(key []byte) *IndexRecord {
+	index.mu.RLock()
+	defer index.mu.RUnlock()
+	node := index.findGreaterOrEqual(key, nil)
+	if node == nil || !bytes.Equal(node.key, key) {
+		return nil
+	}
+	return node.indexRec
+}
+
+func (index *orderedIndex) Set(key []byte, indexRec *IndexRecord) {
+	index.mu.Lock()
+	defer index.mu.Unlock()
+
+	prev := make([]*skipListNode, maxSkipListLevel)
+	node := index.findGreaterOrEqual(key, prev)
+	if node != nil && bytes.Equal(node.key, key) {
+		node.indexRec = indexRec
+		return
+	}
+
+	level := randomSkipListLevel()
+	if level > index.level {
+		for i := index.level; i < level; i++ {
+			prev[i] = index.head
+		}
+		index.level = level
+	}
+
+	node = &skipListNode{
+		key:      bytes.Clone(key),
+		indexRec: indexRec,
+		next:     make([]*skipListNode, level),
+	}
+	for i := 0; i < level; i++ {
+		node.next[i] = prev[i].next[i]
+		prev[i].next[i] = node
+	}
+	index.length++
+}
+
+func (index *orderedIndex) Delete(key []byte) {
+	index.mu.Lock()
+	defer index.mu.Unlock()
+
+	prev := make([]*skipListNode, maxSkipListLevel)
+	node := index.findGreaterOrEqual(key, prev)
+	if node == nil || !bytes.Equal(node.key, key) {
+		return
+	}
+
+	for i := 0; i < len(node.next); i++ {
+		prev[i].next[i] = node.next[i]
+	}
+	for index.level > 1 && index.head.next[index.level-1] == nil {
+		index.level--
+	}
+	index.length--
+}
+
+func (index *orderedIndex) Replace(key []byte, oldRec, newRec *IndexRecord) bool {
+	index.mu.Lock()
+	defer index.mu.Unlock()
+	node := index.findGreaterOrEqual(key, nil)
+	if node == nil || !bytes.Equal(node.key, key) || node.indexRec != oldRec {
+		return false
+	}
+	node.indexRec = newRec
+	return true
+}
+
+func (index *orderedIndex) Range(start, end []byte, reverse bool) []IndexEntry {
+	index.mu.RLock()
+	var entries []IndexEntry
+	node := index.head.next[0]
+	if start != nil {
+		node = index.findGreaterOrEqual(start, nil)
+	}
+	for ; node != nil && inRange(node.key, start, end); node = node.next[0] {
+		entries = append(entries, IndexEntry{Key: bytes.Clone(node.key), indexRec: node.indexRec})
+	}
+	index.mu.RUnlock()
+
+	// The skip list is only linked forwards
+	if reverse {
+		slices.Reverse(entries)
+	}
+	return entries
+}
+
+func (index *orderedIndex) Entries() []IndexEntry {
+	return index.Range(nil, nil, false)
+}
+
+func (index *orderedIndex) Len() int {
+	index.mu.RLock()
+	defer index.mu.RUnlock()
+	return index.length
+}
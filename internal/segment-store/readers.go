@@ -0,0 +1,68 @@
+package segmentstore
+
+import (
+	"errors"
+	"path/filepath"
+
+	bitcask_errors "github.com/nitin-goyal19/bitcask/errors"
+	"github.com/nitin-goyal19/bitcask/internal/utils"
+)
+
+// Name of the file in the directory of the DB on which stores opened read-only hold a
+// shared lock while they are open. A store opened for writing only deletes segment
+// files which have been listed in the manifest while it holds an exclusive lock on
+// it, so that the files are not deleted under a read-only open about to read them.
+const ReadersFileName = "READERS"
+
+// lockOutReaders takes an exclusive lock on the readers file, which keeps the store
+// from being opened read-only till the returned function releases it. It returns a
+// nil function if the store is open read-only.
+func (segStore *SegmentStore) lockOutReaders() (func(), error) {
+	if segStore.readersLock != nil {
+		return func() {}, nil
+	}
+
+	lock, err := utils.LockFile(filepath.Join(segStore.dirPath, ReadersFileName))
+	if errors.Is(err, bitcask_errors.ErrDatabaseLocked) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return func() { lock.Unlock() }, nil
+}
+
+// holdReadersLock keeps the store from being opened read-only till the returned lock
+// is released, failing with ErrDatabaseLocked if it is open read-only.
+func (segStore *SegmentStore) holdReadersLock() (*utils.FileLock, error) {
+	lock, err := utils.LockFile(filepath.Join(segStore.dirPath, ReadersFileName))
+	if err != nil {
+		return nil, err
+	}
+	segStore.readersLock = lock
+	return lock, nil
+}
+
+// removeMergedAwaySegments deletes the files of the segments merged away while the
+// store was open read-only, if it no longer is. Files which are still left when the
+// store is closed are deleted by the next open of the store for writing. It must be
+// called with mu held.
+func (segStore *SegmentStore) removeMergedAwaySegments() error {
+	if len(segStore.mergedAwaySegmentIds) == 0 {
+		return nil
+	}
+
+	unlock, err := segStore.lockOutReaders()
+	if err != nil || unlock == nil {
+		return err
+	}
+	defer unlock()
+
+	for len(segStore.mergedAwaySegmentIds) > 0 {
+		if err := removeSegmentFiles(segStore.segmentDirPath(), segStore.mergedAwaySegmentIds[0]); err != nil {
+			return err
+		}
+		segStore.mergedAwaySegmentIds = segStore.mergedAwaySegmentIds[1:]
+	}
+	return nil
+}
@@ -2,24 +2,56 @@ package segmentstore
 
 import (
 	"encoding/binary"
+	"errors"
 	"time"
 )
 
+var errRecordTooShort = errors.New("record is shorter than its header says")
+
 type RecordType = byte
 
 const (
 	RegularRecord RecordType = iota
 	TombstoneRecord
+	// BatchRecord holds the records of a write batch, encoded one after the other
+	// as its value, so that they are written and replayed all together or not at all.
+	BatchRecord
 )
 
+// Set in the record type byte of records which expire. Their header holds the
+// expiry time, after the timestamp and the sequence number.
+const recordFlagExpiry byte = 0x80
+
+// Set in the record type byte of records whose header holds their sequence number,
+// right after the timestamp. Records written before sequence numbers were added, and
+// the records inside a BatchRecord, which take the sequence number of the batch,
+// do not have it.
+const recordFlagSeq byte = 0x40
+
 // recordType(1 byte) + timestamp(8 byte) + keySize(2 bytes) + valSize(4 bytes)
 const RecordHeaderSize = 1 + 8 + 2 + 4
 
+// expiresAt(8 bytes), present only in the header of records which expire
+const recordExpirySize = 8
+
+// seq(8 bytes), present only in the header of records with recordFlagSeq
+const recordSeqSize = 8
+
+// Offset of the sequence number of a record in its WAL frame
+const frameSeqOffset = WalRecordHeaderSize + 1 + 8
+
+// Records are ordered by their sequence numbers, which the store hands out in the
+// order the records are written. Timestamps are kept as metadata, and only order
+// records without a sequence number, whose sequence number is 0.
 type Record struct {
 	recordType RecordType
 	timestamp  uint64
+	seq        uint64
+	hasSeq     bool   // whether the header of the record holds its sequence number
+	expiresAt  uint64 // unix time in nanoseconds after which the record is expired, 0 if it never is
 	Key        []byte
 	Val        []byte
+	batch      []*Record // records of a BatchRecord
 }
 
 func CreateNewRecord(key, val []byte, recordType RecordType) *Record {
@@ -28,16 +60,55 @@ func CreateNewRecord(key, val []byte, recordType RecordType) *Record {
 		Val:        val,
 		recordType: recordType,
 		timestamp:  uint64(time.Now().UnixNano()),
+		hasSeq:     true,
 	}
 	return record
 }
 
+// CreateExpiringRecord returns a regular record which expires at expiresAt.
+func CreateExpiringRecord(key, val []byte, expiresAt time.Time) *Record {
+	record := CreateNewRecord(key, val, RegularRecord)
+	record.expiresAt = uint64(expiresAt.UnixNano())
+	return record
+}
+
+// CreateBatchRecord returns a BatchRecord holding records. All of them get the
+// timestamp, and later the sequence number, of the batch record.
+func CreateBatchRecord(records []*Record) *Record {
+	batchRecord := CreateNewRecord(nil, nil, BatchRecord)
+
+	size := 0
+	for _, record := range records {
+		record.hasSeq = false
+		size += int(record.WriteSize())
+	}
+	batchRecord.Val = make([]byte, 0, size)
+	for _, record := range records {
+		record.timestamp = batchRecord.timestamp
+		batchRecord.Val = append(batchRecord.Val, GetEncodedRecordHeader(record)...)
+		batchRecord.Val = append(batchRecord.Val, record.Key...)
+		batchRecord.Val = append(batchRecord.Val, record.Val...)
+	}
+	batchRecord.batch = records
+	return batchRecord
+}
+
 func GetEncodedRecordHeader(record *Record) []byte {
-	encodedRecordHeader := make([]byte, RecordHeaderSize)
+	encodedRecordHeader := make([]byte, record.headerSize())
 	encodedRecordHeader[0] = record.recordType
 	index := 1
 	numBytesWritten, _ := binary.Encode(encodedRecordHeader[index:], binary.BigEndian, record.timestamp)
 	index += numBytesWritten
+	if record.hasSeq {
+		encodedRecordHeader[0] |= recordFlagSeq
+		numBytesWritten, _ = binary.Encode(encodedRecordHeader[index:], binary.BigEndian, record.seq)
+		index += numBytesWritten
+	}
+	if record.expiresAt != 0 {
+		encodedRecordHeader[0] |= recordFlagExpiry
+		numBytesWritten, _ = binary.Encode(encodedRecordHeader[index:], binary.BigEndian, record.expiresAt)
+		index += numBytesWritten
+	}
 	numBytesWritten, _ = binary.Encode(encodedRecordHeader[index:], binary.BigEndian, uint16(len(record.Key)))
 	index += numBytesWritten
 	numBytesWritten, _ = binary.Encode(encodedRecordHeader[index:], binary.BigEndian, uint32(len(record.Val)))
@@ -46,55 +117,171 @@ func GetEncodedRecordHeader(record *Record) []byte {
 }
 
 func GetDecodedRecord(recorfBuf []byte) (*Record, error) {
-	recordType := recorfBuf[0]
+	record, _, err := decodeRecord(recorfBuf)
+	if err != nil {
+		return nil, err
+	}
+
+	if record.recordType == BatchRecord {
+		for index := 0; index < len(record.Val); {
+			batchedRecord, numBytesRead, err := decodeRecord(record.Val[index:])
+			if err != nil {
+				return nil, err
+			}
+			batchedRecord.seq = record.seq
+			record.batch = append(record.batch, batchedRecord)
+			index += numBytesRead
+		}
+	}
+	return record, nil
+}
+
+// decodeRecord decodes the record at the start of recordBuf and returns it along with its encoded size.
+func decodeRecord(recorfBuf []byte) (*Record, int, error) {
+	if len(recorfBuf) < RecordHeaderSize {
+		return nil, 0, errRecordTooShort
+	}
+	recordType := recorfBuf[0] &^ (recordFlagExpiry | recordFlagSeq)
 	index := 1
 
 	var timestamp uint64
 	numBytesRead, err := binary.Decode(recorfBuf[index:], binary.BigEndian, &timestamp)
 	if err != nil {
-		return nil, err
+		return nil, 0, err
 	}
 
 	index += numBytesRead
+	var seq uint64
+	hasSeq := recorfBuf[0]&recordFlagSeq != 0
+	if hasSeq {
+		if len(recorfBuf) < index+recordSeqSize+2+4 {
+			return nil, 0, errRecordTooShort
+		}
+		numBytesRead, err = binary.Decode(recorfBuf[index:], binary.BigEndian, &seq)
+		if err != nil {
+			return nil, 0, err
+		}
+		index += numBytesRead
+	}
+
+	var expiresAt uint64
+	if recorfBuf[0]&recordFlagExpiry != 0 {
+		if len(recorfBuf) < index+recordExpirySize+2+4 {
+			return nil, 0, errRecordTooShort
+		}
+		numBytesRead, err = binary.Decode(recorfBuf[index:], binary.BigEndian, &expiresAt)
+		if err != nil {
+			return nil, 0, err
+		}
+		index += numBytesRead
+	}
+
 	var keySize uint16
 	numBytesRead, err = binary.Decode(recorfBuf[index:], binary.BigEndian, &keySize)
 	if err != nil {
-		return nil, err
+		return nil, 0, err
 	}
 
 	index += numBytesRead
 	var valSize uint32
 	numBytesRead, err = binary.Decode(recorfBuf[index:], binary.BigEndian, &valSize)
 	if err != nil {
-		return nil, err
+		return nil, 0, err
 	}
 
 	index += numBytesRead
+	if uint64(len(recorfBuf)-index) < uint64(keySize)+uint64(valSize) {
+		return nil, 0, errRecordTooShort
+	}
 	key := make([]byte, keySize)
 	numBytesRead, err = binary.Decode(recorfBuf[index:], binary.BigEndian, key)
 	if err != nil {
-		return nil, err
+		return nil, 0, err
 	}
 
 	index += numBytesRead
 	val := make([]byte, valSize)
 	numBytesRead, err = binary.Decode(recorfBuf[index:], binary.BigEndian, val)
 	if err != nil {
-		return nil, err
+		return nil, 0, err
 	}
+	index += numBytesRead
 
 	return &Record{
 		recordType: recordType,
 		timestamp:  timestamp,
+		seq:        seq,
+		hasSeq:     hasSeq,
+		expiresAt:  expiresAt,
 		Key:        key,
 		Val:        val,
-	}, nil
+	}, index, nil
+}
+
+// flatten returns the records held by a BatchRecord, or the record itself for any other type.
+func (record *Record) flatten() []*Record {
+	if record.recordType == BatchRecord {
+		return record.batch
+	}
+	return []*Record{record}
+}
+
+func (record *Record) IsTombstone() bool {
+	return record.recordType == TombstoneRecord
+}
+
+func (record *Record) Type() RecordType {
+	return record.recordType
+}
+
+func (record *Record) Timestamp() time.Time {
+	return time.Unix(0, int64(record.timestamp))
+}
+
+// Seq returns the sequence number of the record, which is 0 for records written
+// before records had sequence numbers.
+func (record *Record) Seq() uint64 {
+	return record.seq
+}
+
+// ExpiresAt returns the time after which the record is expired, or the zero time
+// if it never is.
+func (record *Record) ExpiresAt() time.Time {
+	if record.expiresAt == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, int64(record.expiresAt))
+}
+
+// Records returns the records held by a BatchRecord, or the record itself for any other type.
+func (record *Record) Records() []*Record {
+	return record.flatten()
+}
+
+func (record *Record) headerSize() uint64 {
+	size := uint64(RecordHeaderSize)
+	if record.hasSeq {
+		size += recordSeqSize
+	}
+	if record.expiresAt != 0 {
+		size += recordExpirySize
+	}
+	return size
+}
+
+// setSeq sets the sequence number of the record, and of the records inside it if
+// it is a BatchRecord.
+func (record *Record) setSeq(seq uint64) {
+	record.seq = seq
+	for _, batchedRecord := range record.batch {
+		batchedRecord.seq = seq
+	}
 }
 
 func (record *Record) ValOffset() uint64 {
-	return RecordHeaderSize + uint64(len(record.Key))
+	return record.headerSize() + uint64(len(record.Key))
 }
 
 func (record *Record) WriteSize() uint64 {
-	return RecordHeaderSize + uint64(len(record.Key)) + uint64(len(record.Val))
+	return record.headerSize() + uint64(len(record.Key)) + uint64(len(record.Val))
 }
@@ -0,0 +1,372 @@
+package segmentstore
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+
+	"github.com/nitin-goyal19/bitcask/config"
+	bitcask_errors "github.com/nitin-goyal19/bitcask/errors"
+)
+
+// Name of the directory in the directory of the DB to which Repair moves damaged files.
+const quarantineDirName = "quarantine"
+
+// RepairReport describes what Repair found, and what it did about it.
+type RepairReport struct {
+	DryRun bool // whether the store was only checked, as it was opened read-only
+	// Path of the directory damaged files were moved to, empty if there were none
+	QuarantineDir string
+	// Whether the manifest was damaged, in which case the segments are the segment files found
+	ManifestDamaged bool
+	// Segments listed in the manifest whose files are missing
+	MissingSegments []SegmentId
+	Segments        int // number of segments scanned
+	DamagedSegments []DamagedSegment
+	// Number of records of damaged segments which can still be read, and are copied
+	// to new segments
+	SalvagedRecords int
+	// Records known to have been in damaged regions, from the hint files of the damaged
+	// segments or from what is left of the damaged records
+	LostRecords []LostRecord
+	// Keys of LostRecords whose value is lost, as no newer record of the key was salvaged
+	LostKeys [][]byte
+}
+
+type DamagedSegment struct {
+	SegmentId SegmentId
+	HeaderErr error // error reading the header of the segment file, if any
+	Regions   []DamagedRegion
+	// Segment the records salvaged from the segment were copied to, 0 if none were
+	NewSegmentId SegmentId
+}
+
+// DamagedRegion is a run of bytes of a segment file holding no valid frame.
+type DamagedRegion struct {
+	Offset int64
+	Size   int64
+	Err    error // error reading the frame at Offset
+}
+
+type LostRecord struct {
+	Key       []byte
+	SegmentId SegmentId
+	Offset    int64 // offset of the damaged region holding the record
+	version   recordVersion
+}
+
+// scannedSegment is a segment file read by Repair.
+type scannedSegment struct {
+	file    *os.File
+	damaged *DamagedSegment // nil if the segment is not damaged
+	frames  []Frame         // valid frames of a damaged segment
+}
+
+// Repair checks every record of the store in dirPath, which must not be open, and
+// rebuilds the damaged segments out of the records which can still be read. Damaged
+// regions are skipped by looking for the next frame with a valid CRC. The damaged
+// segment files are moved to the quarantine directory, along with a damaged manifest.
+// If cfg.ReadOnly is set, the store is only checked.
+func Repair(dirPath string, cfg *config.Config) (*RepairReport, error) {
+	segStore := GetSegmentStore(dirPath, cfg)
+	report := &RepairReport{DryRun: cfg.ReadOnly}
+	if !report.DryRun {
+		// Segment files are replaced, which read-only opens could be reading
+		lock, err := segStore.holdReadersLock()
+		if err != nil {
+			return nil, fmt.Errorf("DB is open read-only: %w", err)
+		}
+		defer lock.Unlock()
+	}
+
+	segmentIds, err := segStore.repairManifest(report)
+	if err != nil {
+		return nil, err
+	}
+	report.Segments = len(segmentIds)
+
+	// Newest version of every key among the records which can be read
+	versions := make(map[string]recordVersion)
+	var scannedSegments []*scannedSegment
+	defer func() {
+		for _, scanned := range scannedSegments {
+			scanned.file.Close()
+		}
+	}()
+	for _, segmentId := range segmentIds {
+		scanned, err := segStore.scanSegment(segmentId, versions, report)
+		if err != nil {
+			return nil, err
+		}
+		if scanned.damaged != nil {
+			scannedSegments = append(scannedSegments, scanned)
+		} else {
+			scanned.file.Close()
+		}
+	}
+
+	for _, lost := range report.LostRecords {
+		version, ok := versions[string(lost.Key)]
+		if (!ok || version.olderThan(lost.version)) && !slices.ContainsFunc(report.LostKeys, func(key []byte) bool { return string(key) == string(lost.Key) }) {
+			report.LostKeys = append(report.LostKeys, lost.Key)
+		}
+	}
+
+	for _, scanned := range scannedSegments {
+		report.DamagedSegments = append(report.DamagedSegments, *scanned.damaged)
+		for _, frame := range scanned.frames {
+			report.SalvagedRecords += len(frame.Record.flatten())
+		}
+	}
+	if report.DryRun || len(scannedSegments) == 0 {
+		return report, nil
+	}
+
+	quarantineDir, err := segStore.quarantineDir(report)
+	if err != nil {
+		return nil, err
+	}
+
+	liveSegmentIds := slices.Clone(segmentIds)
+	for i, scanned := range scannedSegments {
+		segmentId := scanned.damaged.SegmentId
+		// Linked rather than moved, so that the damaged segment is still there if the
+		// store goes down before the manifest stops listing it
+		for _, fileName := range []string{segmentFileName(segmentId), hintFileName(segmentId)} {
+			if err := os.Link(filepath.Join(segStore.segmentDirPath(), fileName), filepath.Join(quarantineDir, fileName)); err != nil && !os.IsNotExist(err) {
+				return nil, err
+			}
+		}
+
+		liveSegmentIds = slices.DeleteFunc(liveSegmentIds, func(id SegmentId) bool { return id == segmentId })
+		if len(scanned.frames) == 0 {
+			continue
+		}
+		newSegmentId, err := segStore.salvageFrames(scanned)
+		if err != nil {
+			return nil, err
+		}
+		report.DamagedSegments[i].NewSegmentId = newSegmentId
+		liveSegmentIds = append(liveSegmentIds, newSegmentId)
+	}
+
+	slices.Sort(liveSegmentIds)
+	segStore.manifest.segmentIds = liveSegmentIds
+	if err := segStore.manifest.save(); err != nil {
+		return nil, err
+	}
+	for _, scanned := range scannedSegments {
+		if err := removeSegmentFiles(segStore.segmentDirPath(), scanned.damaged.SegmentId); err != nil {
+			return nil, err
+		}
+	}
+	return report, nil
+}
+
+// repairManifest returns the ids of the segments of the store. A damaged manifest
+// is moved to the quarantine directory, and missing segments are dropped from it.
+func (segStore *SegmentStore) repairManifest(report *RepairReport) ([]SegmentId, error) {
+	path := filepath.Join(segStore.dirPath, manifestFileName)
+	m, err := readManifest(path)
+	if errors.Is(err, errInvalidManifest) {
+		log.Printf("Rebuilding damaged manifest from the segment files: %v", err)
+		report.ManifestDamaged = true
+		if !report.DryRun {
+			quarantineDir, err := segStore.quarantineDir(report)
+			if err != nil {
+				return nil, err
+			}
+			if err := os.Rename(path, filepath.Join(quarantineDir, manifestFileName)); err != nil {
+				return nil, err
+			}
+		}
+		m = nil
+	} else if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	if m != nil && m.version == manifestVersion {
+		segmentIds := slices.DeleteFunc(slices.Clone(m.segmentIds), func(segmentId SegmentId) bool {
+			_, err := os.Stat(filepath.Join(segStore.segmentDirPath(), segmentFileName(segmentId)))
+			if os.IsNotExist(err) {
+				report.MissingSegments = append(report.MissingSegments, segmentId)
+				return true
+			}
+			return false
+		})
+		if len(report.MissingSegments) > 0 && !report.DryRun {
+			m.segmentIds = segmentIds
+			if err := m.save(); err != nil {
+				return nil, err
+			}
+		}
+		if report.DryRun {
+			return slices.Sorted(slices.Values(segmentIds)), nil
+		}
+	}
+
+	if report.DryRun {
+		return listSegmentFiles(segStore.segmentDirPath())
+	}
+	// Removes the files left behind by anything which did not finish, and creates a
+	// manifest if the store has none
+	return segStore.loadManifest()
+}
+
+// quarantineDir returns the quarantine directory of this repair, creating it the first time.
+func (segStore *SegmentStore) quarantineDir(report *RepairReport) (string, error) {
+	if report.QuarantineDir == "" {
+		dirPath := filepath.Join(segStore.dirPath, quarantineDirName, time.Now().Format("20060102T150405.000000000"))
+		if err := os.MkdirAll(dirPath, 0751); err != nil {
+			return "", err
+		}
+		report.QuarantineDir = dirPath
+	}
+	return report.QuarantineDir, nil
+}
+
+// scanSegment reads every frame of a segment, recording the versions of the keys
+// of its valid records in versions, and its damaged regions and lost records in
+// report.
+func (segStore *SegmentStore) scanSegment(segmentId SegmentId, versions map[string]recordVersion, report *RepairReport) (*scannedSegment, error) {
+	file, err := os.Open(filepath.Join(segStore.segmentDirPath(), segmentFileName(segmentId)))
+	if err != nil {
+		return nil, err
+	}
+	fileInfo, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	size := fileInfo.Size()
+
+	scanned := &scannedSegment{file: file}
+	damaged := &DamagedSegment{SegmentId: segmentId}
+
+	header, err := ReadSegmentHeader(file, size)
+	if err == nil && header.Version > 0 && header.SegmentId != segmentId {
+		err = fmt.Errorf("%w: header is of segment %d", bitcask_errors.ErrInvalidSegmentHeader, header.SegmentId)
+	}
+	var dataStart int64
+	switch {
+	case errors.Is(err, bitcask_errors.ErrInvalidSegmentHeader):
+		// The frames are still where they are in a segment file with a valid header
+		damaged.HeaderErr = err
+		dataStart = min(SegmentHeaderSize, size)
+		damaged.Regions = append(damaged.Regions, DamagedRegion{Offset: 0, Size: dataStart, Err: err})
+	case errors.Is(err, bitcask_errors.ErrUnknownSegmentVersion):
+		// Nothing in a segment file of another format version can be read
+		damaged.HeaderErr = err
+		dataStart = size
+		damaged.Regions = append(damaged.Regions, DamagedRegion{Offset: 0, Size: size, Err: err})
+	case err != nil:
+		file.Close()
+		return nil, err
+	default:
+		dataStart = int64(header.DataStart())
+	}
+
+	var frames []Frame
+	for frame := range ResyncFrames(file, dataStart, size) {
+		if frame.Err == nil {
+			frames = append(frames, frame)
+			for _, record := range frame.Record.flatten() {
+				version := recordVersion{seq: record.seq, timestamp: record.timestamp}
+				if newest, ok := versions[string(record.Key)]; !ok || newest.olderThan(version) {
+					versions[string(record.Key)] = version
+				}
+			}
+			continue
+		}
+
+		region := DamagedRegion{Offset: frame.Offset, Size: frame.Size, Err: frame.Err}
+		if frame.Size == 0 {
+			file.Close()
+			return nil, fmt.Errorf("segment %d: %w", segmentId, frame.Err)
+		}
+		damaged.Regions = append(damaged.Regions, region)
+		for _, record := range decodeDamagedRegion(file, region.Offset, region.Size) {
+			for _, record := range record.flatten() {
+				report.LostRecords = append(report.LostRecords, LostRecord{
+					Key:       record.Key,
+					SegmentId: segmentId,
+					Offset:    region.Offset,
+					version:   recordVersion{seq: record.seq, timestamp: record.timestamp},
+				})
+			}
+		}
+	}
+
+	if len(damaged.Regions) == 0 {
+		return scanned, nil
+	}
+	log.Printf("Segment %d has %d damaged regions", segmentId, len(damaged.Regions))
+	scanned.damaged = damaged
+	scanned.frames = frames
+
+	// The hint file tells which records were in the damaged regions
+	entries, err := readHintFile(segStore.segmentDirPath(), segmentId, size)
+	if err != nil {
+		return scanned, nil
+	}
+	for _, entry := range entries {
+		offset := int64(entry.recordOffset)
+		idx := slices.IndexFunc(damaged.Regions, func(region DamagedRegion) bool {
+			return offset >= region.Offset && offset < region.Offset+region.Size
+		})
+		if idx < 0 {
+			continue
+		}
+		region := damaged.Regions[idx]
+		if slices.ContainsFunc(report.LostRecords, func(lost LostRecord) bool {
+			return lost.SegmentId == segmentId && lost.Offset == region.Offset && string(lost.Key) == string(entry.key)
+		}) {
+			continue
+		}
+		report.LostRecords = append(report.LostRecords, LostRecord{
+			Key:       entry.key,
+			SegmentId: segmentId,
+			Offset:    region.Offset,
+			version:   recordVersion{seq: entry.seq, timestamp: entry.timestamp},
+		})
+	}
+	return scanned, nil
+}
+
+// salvageFrames copies the valid frames of a damaged segment into a new segment,
+// with its hint file, and returns the id of the new segment. The new segment only
+// becomes part of the store once the manifest lists it.
+func (segStore *SegmentStore) salvageFrames(scanned *scannedSegment) (SegmentId, error) {
+	segmentId := segStore.manifest.nextSegmentId
+	segStore.manifest.nextSegmentId++
+	segment, err := CreateNewSegment(segStore.segmentDirPath(), segmentId)
+	if err != nil {
+		return 0, err
+	}
+	defer segment.Close()
+
+	for _, frame := range scanned.frames {
+		buf := make([]byte, frame.Size)
+		if _, err := scanned.file.ReadAt(buf, frame.Offset); err != nil && err != io.EOF {
+			return 0, err
+		}
+		offset, err := segment.Append(buf)
+		if err != nil {
+			return 0, err
+		}
+		segment.addHints(frame.Record, offset)
+	}
+
+	if err := segment.seal(); err != nil {
+		return 0, err
+	}
+	if err := segment.writeHintFile(segStore.segmentDirPath()); err != nil {
+		return 0, err
+	}
+	return segmentId, nil
+}
@@ -0,0 +1,197 @@
+package segmentstore
+
+import (
+	"fmt"
+	"log"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/nitin-goyal19/bitcask/config"
+	bitcask_errors "github.com/nitin-goyal19/bitcask/errors"
+)
+
+// recordLocation identifies a record by the segment and offset of its WAL frame.
+type recordLocation struct {
+	segmentId    SegmentId
+	recordOffset SegmentOffset
+}
+
+// ScrubStats describes the progress of the background scrubber.
+type ScrubStats struct {
+	Passes       int       // passes over the immutable segments finished so far
+	LastPassEnd  time.Time // zero till the first pass finishes
+	BytesScanned int64     // bytes checked so far by the current pass
+	BytesToScan  int64     // bytes to be checked by the current pass
+	Corruptions  int       // corrupt records found, each counted once however many passes find it
+}
+
+// scrubber checks the CRCs of the records of the immutable segments in the
+// background, at a rate of at most ScrubRate bytes per second.
+type scrubber struct {
+	mu    sync.Mutex
+	stats ScrubStats
+	found map[recordLocation]bool // corrupt records found so far
+	stop  chan struct{}
+	done  chan struct{}
+}
+
+// StartScrubber starts the background scrubber when ScrubInterval is set. It is
+// stopped by Close.
+func (segStore *SegmentStore) StartScrubber() {
+	if segStore.config.ScrubInterval == 0 {
+		return
+	}
+
+	s := &scrubber{
+		found: make(map[recordLocation]bool),
+		stop:  make(chan struct{}),
+		done:  make(chan struct{}),
+	}
+	segStore.scrubber = s
+	go func() {
+		defer close(s.done)
+		timer := time.NewTimer(segStore.config.ScrubInterval)
+		defer timer.Stop()
+		for {
+			select {
+			case <-timer.C:
+				if !segStore.scrub(s) {
+					return
+				}
+				timer.Reset(segStore.config.ScrubInterval)
+			case <-s.stop:
+				return
+			}
+		}
+	}()
+}
+
+// stopScrubber stops the background scrubber, if it is running, and waits for it to return.
+func (segStore *SegmentStore) stopScrubber() {
+	if segStore.scrubber != nil {
+		close(segStore.scrubber.stop)
+		<-segStore.scrubber.done
+	}
+}
+
+// ScrubStats returns the progress of the background scrubber, which is zero if it
+// does not run.
+func (segStore *SegmentStore) ScrubStats() ScrubStats {
+	if segStore.scrubber == nil {
+		return ScrubStats{}
+	}
+	segStore.scrubber.mu.Lock()
+	defer segStore.scrubber.mu.Unlock()
+	return segStore.scrubber.stats
+}
+
+// scrub makes one pass over the immutable segments. It returns false if the
+// scrubber was stopped before the pass finished.
+func (segStore *SegmentStore) scrub(s *scrubber) bool {
+	segStore.mu.RLock()
+	segmentIds := make([]SegmentId, 0, len(segStore.oldSegments))
+	var bytesToScan int64
+	for segmentId, segment := range segStore.oldSegments {
+		segmentIds = append(segmentIds, segmentId)
+		bytesToScan += segment.curSize - int64(segment.dataStart)
+	}
+	// Keeps the segments from being deleted by a merge while they are read
+	segStore.pinSegments(segmentIds)
+	segStore.mu.RUnlock()
+	defer segStore.unpinSegments(segmentIds)
+	slices.Sort(segmentIds)
+
+	s.mu.Lock()
+	s.stats.BytesScanned = 0
+	s.stats.BytesToScan = bytesToScan
+	s.mu.Unlock()
+
+	start := time.Now()
+	var bytesScanned int64
+	for _, segmentId := range segmentIds {
+		segment := segStore.pinnedSegment(segmentId)
+		if segment == nil {
+			return false
+		}
+
+		for frame := range ReadFrames(segment.fd, int64(segment.dataStart), segment.curSize) {
+			if frame.Err != nil {
+				segStore.reportCorruption(s, segmentId, frame)
+			}
+			bytesScanned += frame.Size
+			s.mu.Lock()
+			s.stats.BytesScanned = bytesScanned
+			s.mu.Unlock()
+
+			// Waits till reading bytesScanned bytes at ScrubRate would have taken
+			wait := time.Duration(float64(bytesScanned)/float64(segStore.config.ScrubRate)*float64(time.Second)) - time.Since(start)
+			if wait <= 0 {
+				wait = 0
+			}
+			select {
+			case <-time.After(wait):
+			case <-s.stop:
+				return false
+			}
+		}
+	}
+
+	s.mu.Lock()
+	s.stats.Passes++
+	s.stats.LastPassEnd = time.Now()
+	s.mu.Unlock()
+	return true
+}
+
+// reportCorruption records a damaged frame found by the scrubber, marks the keys
+// whose values are in it if MarkCorruptKeys is set, and calls OnCorruption.
+func (segStore *SegmentStore) reportCorruption(s *scrubber, segmentId SegmentId, frame Frame) {
+	location := recordLocation{segmentId: segmentId, recordOffset: SegmentOffset(frame.Offset)}
+	s.mu.Lock()
+	if s.found[location] {
+		s.mu.Unlock()
+		return
+	}
+	s.found[location] = true
+	s.stats.Corruptions++
+	s.mu.Unlock()
+	log.Printf("Scrubber found a corrupt record at offset %d of segment %d: %v", frame.Offset, segmentId, frame.Err)
+
+	corruption := config.Corruption{SegmentId: segmentId, Offset: frame.Offset, Err: frame.Err}
+	err := fmt.Errorf("%w: record at offset %d of segment %d: %w", bitcask_errors.ErrCorrupted, frame.Offset, segmentId, frame.Err)
+	frameEnd := frame.Offset + max(frame.Size, 1)
+
+	// The index has a lock of its own, so mu is only taken to mark the keys found. Keys
+	// written in the meantime are marked at a location their reads no longer look up.
+	var locations []recordLocation
+	for _, entry := range segStore.index.Entries() {
+		indexRec := entry.indexRec
+		if indexRec.segmentId != segmentId || int64(indexRec.recordOffset) < frame.Offset || int64(indexRec.recordOffset) >= frameEnd {
+			continue
+		}
+		corruption.Keys = append(corruption.Keys, entry.Key)
+		locations = append(locations, recordLocation{segmentId: segmentId, recordOffset: indexRec.recordOffset})
+	}
+
+	if segStore.config.MarkCorruptKeys && len(locations) > 0 {
+		segStore.mu.Lock()
+		// Marks are dropped along with the segment when it is merged away
+		if segStore.getSegment(segmentId) != nil {
+			for _, location := range locations {
+				segStore.corruptRecords[location] = err
+			}
+		}
+		segStore.mu.Unlock()
+	}
+
+	if segStore.config.OnCorruption != nil {
+		segStore.config.OnCorruption(corruption)
+	}
+}
+
+// corruptRecordErr returns ErrCorrupted if the scrubber found the record of an
+// index record corrupt. It must be called with mu held.
+func (segStore *SegmentStore) corruptRecordErr(indexRec *IndexRecord) error {
+	return segStore.corruptRecords[recordLocation{segmentId: indexRec.segmentId, recordOffset: indexRec.recordOffset}]
+}
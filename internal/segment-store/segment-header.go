@@ -0,0 +1,87 @@
+package segmentstore
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"time"
+
+	bitcask_errors "github.com/nitin-goyal19/bitcask/errors"
+)
+
+// Format version of the segment files written by the store. Version 0 stands for
+// the segment files written before segment files had a header, which start right
+// away with the first WAL frame. They are still read, and are rewritten with a
+// header when they are merged.
+const SegmentFormatVersion = 1
+
+var segmentMagic = []byte("BCSG")
+
+// magic(4 bytes) + version(2 bytes) + flags(2 bytes) + segmentId(8 bytes) + creation time(8 bytes) + CRC(4 bytes) of everything before it
+const SegmentHeaderSize = 4 + 2 + 2 + 8 + 8 + 4
+
+// SegmentHeader is the header at the start of a segment file.
+type SegmentHeader struct {
+	Version   uint16
+	Flags     uint16 // none are defined yet
+	SegmentId SegmentId
+	CreatedAt time.Time
+}
+
+// DataStart returns the offset of the first WAL frame in a segment file with the header.
+func (header *SegmentHeader) DataStart() SegmentOffset {
+	if header.Version == 0 {
+		return 0
+	}
+	return SegmentHeaderSize
+}
+
+func encodeSegmentHeader(header *SegmentHeader) []byte {
+	buf := make([]byte, 0, SegmentHeaderSize)
+	buf = append(buf, segmentMagic...)
+	buf = binary.BigEndian.AppendUint16(buf, header.Version)
+	buf = binary.BigEndian.AppendUint16(buf, header.Flags)
+	buf = binary.BigEndian.AppendUint64(buf, uint64(header.SegmentId))
+	buf = binary.BigEndian.AppendUint64(buf, uint64(header.CreatedAt.UnixNano()))
+	return binary.BigEndian.AppendUint32(buf, crc32.ChecksumIEEE(buf))
+}
+
+// ReadSegmentHeader reads the header of a segment file of size bytes. A file which
+// does not start with the magic number is taken to be a segment file of version 0,
+// and the returned header only has its version set.
+func ReadSegmentHeader(file io.ReaderAt, size int64) (*SegmentHeader, error) {
+	if size < int64(len(segmentMagic)) {
+		return &SegmentHeader{}, nil
+	}
+
+	buf := make([]byte, min(size, SegmentHeaderSize))
+	if _, err := file.ReadAt(buf, 0); err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(buf[:len(segmentMagic)], segmentMagic) {
+		return &SegmentHeader{}, nil
+	}
+
+	if len(buf) < SegmentHeaderSize {
+		return nil, fmt.Errorf("%w: file is shorter than the header", bitcask_errors.ErrInvalidSegmentHeader)
+	}
+	if crc32.ChecksumIEEE(buf[:SegmentHeaderSize-4]) != binary.BigEndian.Uint32(buf[SegmentHeaderSize-4:]) {
+		return nil, fmt.Errorf("%w: checksum mismatch", bitcask_errors.ErrInvalidSegmentHeader)
+	}
+
+	header := &SegmentHeader{
+		Version:   binary.BigEndian.Uint16(buf[4:]),
+		Flags:     binary.BigEndian.Uint16(buf[6:]),
+		SegmentId: SegmentId(binary.BigEndian.Uint64(buf[8:])),
+		CreatedAt: time.Unix(0, int64(binary.BigEndian.Uint64(buf[16:]))),
+	}
+	if header.Version == 0 || header.Version > SegmentFormatVersion {
+		return nil, fmt.Errorf("%w: version %d", bitcask_errors.ErrUnknownSegmentVersion, header.Version)
+	}
+	if header.Flags != 0 {
+		return nil, fmt.Errorf("%w: unknown flags %#x", bitcask_errors.ErrInvalidSegmentHeader, header.Flags)
+	}
+	return header, nil
+}
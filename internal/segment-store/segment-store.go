@@ -1,205 +1,660 @@
 package segmentstore
 
 import (
+	"bytes"
 	"encoding/binary"
+	"errors"
+	"fmt"
 	"log"
 	"os"
-	"path"
 	"path/filepath"
-	"strconv"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/nitin-goyal19/bitcask/config"
 	bitcask_errors "github.com/nitin-goyal19/bitcask/errors"
+	"github.com/nitin-goyal19/bitcask/internal/utils"
 )
 
 type SegmentStore struct {
-	activeSegment  *Segment
-	oldSegments    map[SegmentId]*Segment
-	mu             sync.RWMutex
-	recordMetadata []byte
-	index          *Index
-	config         *config.Config
+	activeSegment   *Segment
+	oldSegments     map[SegmentId]*Segment
+	mu              sync.RWMutex
+	recordMetadata  []byte
+	index           Index
+	config          *config.Config
+	dirPath         string // directory of the DB holding the segments and merged segments directories
+	manifest        *manifest
+	lastSeq         uint64 // sequence number of the last record written, handed out by the committer
+	lastDeleteSeq   uint64 // sequence number of the last tombstone written since the store was opened
+	isMerging       atomic.Bool
+	hintWriters     sync.WaitGroup
+	unsyncedBytes   int64
+	stopSyncer      chan struct{}
+	syncerDone      chan struct{}
+	writeRequests   chan *writeRequest
+	stopCommitter   chan struct{}
+	committerDone   chan struct{}
+	commitBuf       []byte // reused by the committer to gather the frames of a group
+	pinMu           sync.Mutex
+	pins            map[SegmentId]int      // number of snapshots reading from each segment
+	retiredSegments map[SegmentId]*Segment // merged away segments kept open for the snapshots pinning them
+	scrubber        *scrubber
+	corruptRecords  map[recordLocation]error // records the scrubber found corrupt, when MarkCorruptKeys is set
+	crcFailures     atomic.Int64             // reads whose record failed its CRC check, when VerifyChecksums is set
+	closed          atomic.Bool              // set by the first Close, which is the only one to stop anything
+	// Segments merged away while the store was open read-only, whose files are left in place
+	mergedAwaySegmentIds []SegmentId
+	readersLock          *utils.FileLock // held for as long as the store exists, by stores which can not share the DB with readers
 }
 
-func GetSegmentStore(config *config.Config) *SegmentStore {
+func GetSegmentStore(dirPath string, config *config.Config) *SegmentStore {
 	return &SegmentStore{
-		recordMetadata: make([]byte, binary.MaxVarintLen64+binary.MaxVarintLen32),
-		index:          CreateIndex(),
-		oldSegments:    make(map[SegmentId]*Segment),
-		config:         config,
+		recordMetadata:  make([]byte, binary.MaxVarintLen64+binary.MaxVarintLen32),
+		index:           CreateIndex(config.IndexType),
+		oldSegments:     make(map[SegmentId]*Segment),
+		pins:            make(map[SegmentId]int),
+		retiredSegments: make(map[SegmentId]*Segment),
+		corruptRecords:  make(map[recordLocation]error),
+		config:          config,
+		dirPath:         dirPath,
 	}
 }
 
+func (segmentStore *SegmentStore) segmentDirPath() string {
+	return filepath.Join(segmentStore.dirPath, segmentStore.config.GetSegmentDirName())
+}
+
+func (segmentStore *SegmentStore) mergeDirPath() string {
+	return filepath.Join(segmentStore.dirPath, segmentStore.config.GetMergeSegmentDirName())
+}
+
 func (segmentStore *SegmentStore) InitializeSegmentStore() error {
-	dirPath := filepath.Join(segmentStore.config.DataDirectory, segmentStore.config.GetSegmentDirName())
-	segmentFiles, error := os.ReadDir(dirPath)
+	segmentIds, err := segmentStore.loadManifest()
+	if err != nil {
+		return err
+	}
+	if segmentStore.manifest.nextSeq > 0 {
+		// Records with the highest sequence numbers may have been merged away
+		segmentStore.lastSeq = segmentStore.manifest.nextSeq - 1
+	}
 
-	if error != nil {
-		return error
+	dirPath := segmentStore.segmentDirPath()
+
+	// Only the segment which was active when the store went down can end with records
+	// written partially by a crash. Damage to any other segment is corruption, whether
+	// or not the segment has a hint file. Manifests which do not record the active
+	// segment were written while it was the newest segment.
+	tailSegmentId := segmentStore.manifest.activeSegmentId
+	if tailSegmentId < 0 && len(segmentIds) > 0 {
+		tailSegmentId = segmentIds[len(segmentIds)-1]
 	}
 
-	for _, segmentFile := range segmentFiles {
-		segmentId, err := strconv.ParseInt(segmentFile.Name(), 10, 64)
+	// Versions of keys deleted during replay, so that an older value of the key
+	// replayed after its tombstone does not resurrect it. Expired values are
+	// replayed like tombstones.
+	tombstones := make(map[string]recordVersion)
+	now := uint64(time.Now().UnixNano())
+
+	for _, segmentId := range segmentIds {
+		segment, err := OpenSegment(dirPath, segmentId)
+		if os.IsNotExist(err) {
+			// Only checked beforehand when the store is opened for writing
+			return fmt.Errorf("%w: segment %d", bitcask_errors.ErrMissingSegment, segmentId)
+		}
 		if err != nil {
-			log.Print("Error while convertion segment id from string to int")
+			return err
 		}
 
-		segment, err := OpenSegment(dirPath, segmentId)
+		entries, err := segmentStore.readSegmentEntries(segment, segment.id == tailSegmentId)
 		if err != nil {
 			return err
 		}
 
-		var offset SegmentOffset = 0
-		for {
-			recordBuf, recordOffset, numBytesRead, error := segment.ReadEncodeRecordWithCrcCheck(offset)
-			if error != nil {
-				return error
+		for _, entry := range entries {
+			segmentStore.lastSeq = max(segmentStore.lastSeq, entry.seq)
+			version := recordVersion{seq: entry.seq, timestamp: entry.timestamp}
+			if deletedAt, ok := tombstones[string(entry.key)]; ok && version.olderThan(deletedAt) {
+				continue
 			}
-			if numBytesRead == 0 {
-				break
+
+			if haveToUpdateIndex := isLatestVersion(segmentStore.index, entry.key, version); haveToUpdateIndex {
+				if entry.recordType == RegularRecord && (entry.expiresAt == 0 || entry.expiresAt > now) {
+					delete(tombstones, string(entry.key))
+					segmentStore.index.Set(entry.key, entry.indexRecord(segment.id))
+				} else {
+					segmentStore.index.Delete(entry.key)
+					tombstones[string(entry.key)] = version
+				}
 			}
+		}
+		if segment.id == segmentIds[len(segmentIds)-1] {
+			// Kept in case the newest segment becomes the active segment again
+			segment.hints = entries
+		}
+		segmentStore.oldSegments[segment.id] = segment
+	}
+	return nil
+}
+
+// readSegmentEntries returns the hint entries of all records of a segment, from
+// its hint file if it has a valid one or else by reading the segment.
+func (segmentStore *SegmentStore) readSegmentEntries(segment *Segment, isTail bool) ([]hintEntry, error) {
+	dirPath := segmentStore.segmentDirPath()
+	entries, err := readHintFile(dirPath, segment.id, segment.curSize)
+	if err == nil {
+		return entries, nil
+	}
+	if !os.IsNotExist(err) {
+		log.Printf("Ignoring hint file of segment %d: %v", segment.id, err)
+	}
 
-			record, error := GetDecodedRecord(recordBuf)
+	entries, validSize, err := segment.scanHintEntries()
+	if err != nil {
+		if !errors.Is(err, bitcask_errors.ErrCrcVerificationFailed) && !errors.Is(err, bitcask_errors.ErrIncompleteRecord) {
+			return nil, err
+		}
 
-			if error != nil {
-				log.Print("Error while decoding record")
-				return error
+		droppedSize := uint64(segment.curSize) - validSize
+		switch {
+		case isTail && segmentStore.config.ReadOnly:
+			log.Printf("Ignoring %d bytes at the end of segment %d from offset %d, written partially before a crash: %v", droppedSize, segment.id, validSize, err)
+			segment.curSize = int64(validSize)
+			return entries, nil
+		case isTail:
+			log.Printf("Truncating segment %d to %d bytes, dropping %d bytes of records written partially before a crash: %v", segment.id, validSize, droppedSize, err)
+			if err := segment.truncate(dirPath, int64(validSize)); err != nil {
+				return nil, err
 			}
+		case segmentStore.config.CorruptionPolicy == config.CorruptionSkip:
+			log.Printf("Skipping %d bytes of segment %d from offset %d: %v", droppedSize, segment.id, validSize, err)
+			segment.curSize = int64(validSize)
+			// The segment file is left as is, so it can not be described by a hint file
+			return entries, nil
+		default:
+			return nil, fmt.Errorf("segment %d is corrupt at offset %d: %w", segment.id, validSize, err)
+		}
+	}
 
-			if haveToUpdateIndex := segmentStore.index.CompareTimestamp(record.Key, record.timestamp); haveToUpdateIndex {
-				if record.recordType == RegularRecord {
-					valueOffset := recordOffset + record.ValOffset()
-					valueSize := uint32(len(record.Val))
-					segmentStore.index.Set(record.Key, &IndexRecord{
-						segmentId:    segment.id,
-						valueSize:    valueSize,
-						valueOffset:  valueOffset,
-						recordOffset: offset,
-						timestamp:    record.timestamp,
-					})
-				} else {
-					segmentStore.index.Delete(record.Key)
+	if segmentStore.config.ReadOnly {
+		return entries, nil
+	}
+	if err := writeHintFile(dirPath, segment.id, segment.curSize, entries); err != nil {
+		log.Printf("Error while writing hint file of segment %d: %v", segment.id, err)
+	}
+	return entries, nil
+}
+
+// nextSegmentId allocates the id of a segment written by a merge. Ids are handed out
+// in increasing order, and the manifest is updated before an id is used, so that no
+// id is ever handed out twice. It must be called with mu held.
+func (segStore *SegmentStore) nextSegmentId() (SegmentId, error) {
+	segmentId := segStore.manifest.nextSegmentId
+	segStore.manifest.nextSegmentId++
+	if err := segStore.manifest.save(); err != nil {
+		segStore.manifest.nextSegmentId--
+		return 0, err
+	}
+	return segmentId, nil
+}
+
+// OpenActiveSegment makes the newest segment the active segment if it has room left
+// under SegmentSize, so that opening the store does not leave one more small segment
+// behind each time, and otherwise starts a new segment.
+func (segStore *SegmentStore) OpenActiveSegment() error {
+	segStore.mu.Lock()
+	defer segStore.mu.Unlock()
+
+	var newestSegment *Segment
+	for _, segment := range segStore.oldSegments {
+		if newestSegment == nil || segment.id > newestSegment.id {
+			newestSegment = segment
+		}
+	}
+
+	if newestSegment != nil {
+		if newestSegment.curSize < segStore.config.SegmentSize {
+			err := newestSegment.reopenForAppend(segStore.segmentDirPath())
+			if err == nil {
+				delete(segStore.oldSegments, newestSegment.id)
+				segStore.activeSegment = newestSegment
+				if segStore.manifest.activeSegmentId == newestSegment.id {
+					return nil
 				}
+				return segStore.saveManifest()
 			}
-			offset += numBytesRead
+			log.Printf("Error while reopening segment %d for writing, starting a new segment: %v", newestSegment.id, err)
 		}
-		segmentStore.oldSegments[segment.id] = segment
+		newestSegment.hints = nil
 	}
-	return nil
+	return segStore.OpenNewSegmentFile()
 }
 
 func (segStore *SegmentStore) OpenNewSegmentFile() error {
 	// segStore.mu.Lock()
 	// defer segStore.mu.Unlock()
-	segmentId := time.Now().UnixMilli()
-	segment, err := CreateNewSegment(path.Join(segStore.config.DataDirectory, "segments"), segmentId)
+	if segStore.activeSegment != nil && segStore.config.SyncPolicy != config.SyncNever {
+		if err := segStore.activeSegment.Sync(); err != nil {
+			return err
+		}
+		segStore.unsyncedBytes = 0
+	}
 
+	// The new segment is only part of the store once the manifest lists it, so it is
+	// dropped as a stray file when the store goes down before then
+	segment, err := CreateNewSegment(segStore.segmentDirPath(), segStore.manifest.nextSegmentId)
 	if err != nil {
 		return err
 	}
+	segStore.manifest.nextSegmentId++
 
-	if segStore.activeSegment != nil {
-		segStore.activeSegment.isActive = false
-		segStore.oldSegments[segStore.activeSegment.id] = segStore.activeSegment
+	prevSegment := segStore.activeSegment
+	if prevSegment != nil {
+		segStore.oldSegments[prevSegment.id] = prevSegment
 	}
 	segStore.activeSegment = segment
+	if err := segStore.saveManifest(); err != nil {
+		if prevSegment != nil {
+			delete(segStore.oldSegments, prevSegment.id)
+		}
+		segStore.activeSegment = prevSegment
+		segment.Close()
+		return err
+	}
+
+	if prevSegment != nil {
+		prevSegment.isActive = false
+		segStore.writeHintFileAsync(prevSegment)
+	}
 	return nil
 }
 
+// writeHintFileAsync writes the hint file of a segment which has become immutable in the background.
+func (segStore *SegmentStore) writeHintFileAsync(segment *Segment) {
+	segStore.hintWriters.Add(1)
+	go func() {
+		defer segStore.hintWriters.Done()
+		if err := segment.writeHintFile(segStore.segmentDirPath()); err != nil {
+			log.Printf("Error while writing hint file of segment %d: %v", segment.id, err)
+		}
+	}()
+}
+
+// StartSyncer starts flushing the active segment in the background when the
+// sync policy is SyncPeriodically. It is stopped by Close.
+func (segStore *SegmentStore) StartSyncer() {
+	if segStore.config.SyncPolicy != config.SyncPeriodically {
+		return
+	}
+
+	segStore.stopSyncer = make(chan struct{})
+	segStore.syncerDone = make(chan struct{})
+	go func() {
+		defer close(segStore.syncerDone)
+		ticker := time.NewTicker(segStore.config.SyncInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if err := segStore.Sync(); err != nil {
+					log.Printf("Error while syncing segment: %v", err)
+				}
+			case <-segStore.stopSyncer:
+				return
+			}
+		}
+	}()
+}
+
+// Sync flushes the writes made to the active segment to disk.
+func (segStore *SegmentStore) Sync() error {
+	if segStore.config.ReadOnly {
+		return nil
+	}
+
+	segStore.mu.Lock()
+	defer segStore.mu.Unlock()
+	segStore.unsyncedBytes = 0
+	return segStore.activeSegment.Sync()
+}
+
+// syncAfterWrite flushes the active segment after numBytes were written to it
+// if the sync policy asks for it.
+func (segStore *SegmentStore) syncAfterWrite(numBytes int64) error {
+	switch segStore.config.SyncPolicy {
+	case config.SyncEveryWrite:
+		return segStore.activeSegment.Sync()
+	case config.SyncEveryNBytes:
+		segStore.unsyncedBytes += numBytes
+		if segStore.unsyncedBytes >= segStore.config.SyncBytes {
+			segStore.unsyncedBytes = 0
+			return segStore.activeSegment.Sync()
+		}
+	}
+	return nil
+}
+
+// Close stops the background work of the store and closes its segments. Closing a
+// closed store returns ErrDbClosed.
 func (segmentStore *SegmentStore) Close() error {
+	if !segmentStore.closed.CompareAndSwap(false, true) {
+		return bitcask_errors.ErrDbClosed
+	}
+
+	if segmentStore.stopCommitter != nil {
+		close(segmentStore.stopCommitter)
+		<-segmentStore.committerDone
+	}
+	if segmentStore.stopSyncer != nil {
+		close(segmentStore.stopSyncer)
+		<-segmentStore.syncerDone
+	}
+	segmentStore.stopScrubber()
+
 	segmentStore.mu.Lock()
 	defer segmentStore.mu.Unlock()
+
+	// A store opened read-only has no active segment, and nothing to write before closing
+	if segmentStore.activeSegment != nil {
+		if err := segmentStore.closeActiveSegment(); err != nil {
+			return err
+		}
+	}
 	for _, segment := range segmentStore.oldSegments {
 		if err := segment.Close(); err != nil {
 			return err
 		}
 	}
 
-	if err := segmentStore.activeSegment.Close(); err != nil {
-		return err
+	segmentStore.pinMu.Lock()
+	defer segmentStore.pinMu.Unlock()
+	for _, segment := range segmentStore.retiredSegments {
+		segment.Close()
+		// Segments retired while the store was open read-only were not renamed
+		if err := os.Remove(filepath.Join(segmentStore.segmentDirPath(), retiredFileName(segment.id))); err != nil && !os.IsNotExist(err) {
+			return err
+		}
 	}
+	clear(segmentStore.retiredSegments)
 
-	return nil
-}
-
-func (segmentstore *SegmentStore) storeRecord(walRecordHeaderBuf, recordHeaderBuf []byte, record *Record) (SegmentOffset, SegmentOffset, error) {
-	valOffset, recordOffset, err := segmentstore.activeSegment.Write(walRecordHeaderBuf, recordHeaderBuf, record)
-	return valOffset, recordOffset, err
+	return segmentStore.removeMergedAwaySegments()
 }
 
-func (segmentstore *SegmentStore) Write(record *Record, recordType RecordType) error {
-	recordHeaderBuf := GetEncodedRecordHeader(record)
-	walRecordHeaderBuf := GetWalRecordHeader(recordHeaderBuf, record)
-
-	segmentstore.mu.Lock()
-	defer segmentstore.mu.Unlock()
+// closeActiveSegment makes the writes to the active segment durable, along with its
+// hint file and the manifest, and closes it. It must be called with mu held.
+func (segmentStore *SegmentStore) closeActiveSegment() error {
+	if segmentStore.config.SyncPolicy != config.SyncNever {
+		if err := segmentStore.activeSegment.Sync(); err != nil {
+			return err
+		}
+	}
 
-	if record.WriteSize()+uint64(len(walRecordHeaderBuf)) > uint64(segmentstore.config.SegmentSize-segmentstore.activeSegment.curSize) {
-		segmentstore.OpenNewSegmentFile()
+	segmentStore.hintWriters.Wait()
+	if !segmentStore.activeSegment.isEmpty() {
+		if err := segmentStore.activeSegment.writeHintFile(segmentStore.segmentDirPath()); err != nil {
+			log.Printf("Error while writing hint file of segment %d: %v", segmentStore.activeSegment.id, err)
+		}
 	}
-	valOffset, recordOffset, err := segmentstore.storeRecord(walRecordHeaderBuf, recordHeaderBuf, record)
-	if err != nil {
+	// Keeps the sequence numbers of the records written till now from being handed out again
+	if err := segmentStore.saveManifest(); err != nil {
 		return err
 	}
-	segmentstore.index.Set(record.Key, &IndexRecord{
-		segmentId:    segmentstore.activeSegment.id,
-		valueSize:    uint32(len(record.Val)),
-		valueOffset:  valOffset,
-		recordOffset: recordOffset,
-		timestamp:    record.timestamp,
-	})
-	return nil
+	return segmentStore.activeSegment.Close()
+}
+
+func (segmentstore *SegmentStore) Write(record *Record, recordType RecordType) error {
+	return segmentstore.submit(record)
 }
 
 func (segmentstore *SegmentStore) Read(key []byte) ([]byte, error) {
 	segmentstore.mu.RLock()
 	defer segmentstore.mu.RUnlock()
-	indexRec := segmentstore.index.Get(key)
+	indexRec := getLive(segmentstore.index, key)
 	if indexRec == nil {
 		return nil, bitcask_errors.ErrKeyNotFound
 	}
 
-	var segment *Segment
-	if segmentstore.activeSegment.id == indexRec.segmentId {
-		segment = segmentstore.activeSegment
-	} else {
-		segment = segmentstore.oldSegments[indexRec.segmentId]
-	}
+	return segmentstore.readValue(indexRec)
+}
+
+// LastSeq returns the sequence number of the last record written. Every write made
+// till then is in the index, and every write made after it has a greater one.
+func (segmentstore *SegmentStore) LastSeq() uint64 {
+	segmentstore.mu.RLock()
+	defer segmentstore.mu.RUnlock()
+	return segmentstore.lastSeq
+}
 
-	value, error := segment.Read(indexRec.valueOffset, uint64(indexRec.valueSize))
+// ReadVersion is Read which also returns the version of the key, which changes
+// whenever the key is written. The version is 0 if the key is not found.
+//
+// ReadVersion is for readers which must see the store as it was at sequence number
+// asOfSeq: it fails with ErrConflict if the key has been written after asOfSeq. As
+// deleted keys are not kept in the index, a key which is not found fails it if any
+// key has been deleted after asOfSeq.
+func (segmentstore *SegmentStore) ReadVersion(key []byte, asOfSeq uint64) ([]byte, uint64, error) {
+	segmentstore.mu.RLock()
+	defer segmentstore.mu.RUnlock()
+	indexRec := getLive(segmentstore.index, key)
+	if indexRec == nil {
+		if segmentstore.lastDeleteSeq > asOfSeq {
+			return nil, 0, bitcask_errors.ErrConflict
+		}
+		return nil, 0, bitcask_errors.ErrKeyNotFound
+	}
+	if indexRec.seq > asOfSeq {
+		return nil, 0, bitcask_errors.ErrConflict
+	}
 
+	value, error := segmentstore.readValue(indexRec)
 	if error != nil {
-		return nil, error
+		return nil, 0, error
 	}
+	return value, indexRec.version(), nil
+}
+
+// getSegment returns the segment with segmentId, or nil if the store does not have it anymore.
+func (segmentstore *SegmentStore) getSegment(segmentId SegmentId) *Segment {
+	if segmentstore.activeSegment != nil && segmentstore.activeSegment.id == segmentId {
+		return segmentstore.activeSegment
+	}
+	return segmentstore.oldSegments[segmentId]
+}
 
-	return value, nil
+// readValue reads the value an index record points to. It must be called with mu held.
+func (segmentstore *SegmentStore) readValue(indexRec *IndexRecord) ([]byte, error) {
+	if err := segmentstore.corruptRecordErr(indexRec); err != nil {
+		return nil, err
+	}
+	return segmentstore.readSegmentValue(segmentstore.getSegment(indexRec.segmentId), indexRec)
+}
+
+// readSegmentValue reads the value an index record points to from the segment
+// holding it, checking the CRC of its record if VerifyChecksums is set.
+func (segmentstore *SegmentStore) readSegmentValue(segment *Segment, indexRec *IndexRecord) ([]byte, error) {
+	if !segmentstore.config.VerifyChecksums {
+		return segment.Read(indexRec.valueOffset, uint64(indexRec.valueSize))
+	}
+
+	value, err := segment.ReadVerifiedValue(indexRec.recordOffset, indexRec.valueOffset, uint64(indexRec.valueSize))
+	if errors.Is(err, bitcask_errors.ErrCrcVerificationFailed) || errors.Is(err, bitcask_errors.ErrIncompleteRecord) {
+		segmentstore.crcFailures.Add(1)
+	}
+	return value, err
+}
+
+// Entries returns the index entries of all keys in the store. As the index is not
+// updated while mu is held, the entries reflect every write made till then, and
+// none made after.
+func (segmentstore *SegmentStore) Entries() []IndexEntry {
+	segmentstore.mu.RLock()
+	defer segmentstore.mu.RUnlock()
+	return liveEntries(segmentstore.index.Entries())
+}
+
+// RangeEntries returns the index entries of keys in [start, end), in key order or
+// in reverse key order, as of the time of the call.
+func (segmentstore *SegmentStore) RangeEntries(start, end []byte, reverse bool) []IndexEntry {
+	segmentstore.mu.RLock()
+	defer segmentstore.mu.RUnlock()
+	return liveEntries(segmentstore.index.Range(start, end, reverse))
+}
+
+// ReadEntry reads the value of an entry returned by Entries. If the segment holding it
+// has been merged since, the value is read from wherever the key now points to, and
+// ErrKeyNotFound is returned if the key has been deleted.
+func (segmentstore *SegmentStore) ReadEntry(entry IndexEntry) ([]byte, error) {
+	segmentstore.mu.RLock()
+	defer segmentstore.mu.RUnlock()
+
+	indexRec := entry.indexRec
+	if segmentstore.getSegment(indexRec.segmentId) == nil {
+		if indexRec = getLive(segmentstore.index, entry.Key); indexRec == nil {
+			return nil, bitcask_errors.ErrKeyNotFound
+		}
+	}
+	return segmentstore.readValue(indexRec)
+}
+
+// WriteBatch writes records as one BatchRecord, so that either all of them or none
+// survive a crash, and makes all of them visible to readers at once.
+func (segmentstore *SegmentStore) WriteBatch(records []*Record) error {
+	return segmentstore.submit(CreateBatchRecord(records))
+}
+
+// WriteBatchIfUnchanged is WriteBatch which fails with ErrConflict, without writing
+// anything, if the version of any key of readVersions is not the one returned for
+// it by ReadVersion anymore, i.e. if the key has been written since.
+func (segmentstore *SegmentStore) WriteBatchIfUnchanged(records []*Record, readVersions map[string]uint64) error {
+	return segmentstore.submitIf(CreateBatchRecord(records), func() error {
+		for key, version := range readVersions {
+			var curVersion uint64
+			if indexRec := getLive(segmentstore.index, []byte(key)); indexRec != nil {
+				curVersion = indexRec.version()
+			}
+			if curVersion != version {
+				return bitcask_errors.ErrConflict
+			}
+		}
+		return nil
+	})
+}
+
+// CompareAndSwap sets key to val if its value is expected. It fails with
+// ErrKeyNotFound if the key does not exist and with ErrValueMismatch if its value
+// is something else.
+func (segmentstore *SegmentStore) CompareAndSwap(key, expected, val []byte) error {
+	record := CreateNewRecord(key, val, RegularRecord)
+	return segmentstore.submitIf(record, segmentstore.valueEquals(key, expected))
+}
+
+// SetIfNotExists sets key to val if the key does not exist, and fails with ErrKeyExists otherwise.
+func (segmentstore *SegmentStore) SetIfNotExists(key, val []byte) error {
+	record := CreateNewRecord(key, val, RegularRecord)
+	return segmentstore.submitIf(record, func() error {
+		if getLive(segmentstore.index, key) != nil {
+			return bitcask_errors.ErrKeyExists
+		}
+		return nil
+	})
+}
+
+// DeleteIfEquals deletes key if its value is expected. It fails like CompareAndSwap otherwise.
+func (segmentstore *SegmentStore) DeleteIfEquals(key, expected []byte) error {
+	record := CreateNewRecord(key, nil, TombstoneRecord)
+	return segmentstore.submitIf(record, segmentstore.valueEquals(key, expected))
+}
+
+// valueEquals returns a check for submitIf which passes if the value of key is expected.
+func (segmentstore *SegmentStore) valueEquals(key, expected []byte) func() error {
+	return func() error {
+		indexRec := getLive(segmentstore.index, key)
+		if indexRec == nil {
+			return bitcask_errors.ErrKeyNotFound
+		}
+		if uint64(indexRec.valueSize) != uint64(len(expected)) {
+			return bitcask_errors.ErrValueMismatch
+		}
+
+		value, error := segmentstore.readValue(indexRec)
+		if error != nil {
+			return error
+		}
+		if !bytes.Equal(value, expected) {
+			return bitcask_errors.ErrValueMismatch
+		}
+		return nil
+	}
+}
+
+// TTL returns the time at which the value of key expires, or the zero time if it never does.
+func (segmentstore *SegmentStore) TTL(key []byte) (time.Time, error) {
+	segmentstore.mu.RLock()
+	defer segmentstore.mu.RUnlock()
+	indexRec := getLive(segmentstore.index, key)
+	if indexRec == nil {
+		return time.Time{}, bitcask_errors.ErrKeyNotFound
+	}
+	if indexRec.expiresAt == 0 {
+		return time.Time{}, nil
+	}
+	return time.Unix(0, int64(indexRec.expiresAt)), nil
+}
+
+// Persist removes the expiry time of the value of key, by writing the value again
+// without one.
+func (segmentstore *SegmentStore) Persist(key []byte) error {
+	errKeyChanged := errors.New("key changed")
+	for {
+		segmentstore.mu.RLock()
+		indexRec := getLive(segmentstore.index, key)
+		if indexRec == nil {
+			segmentstore.mu.RUnlock()
+			return bitcask_errors.ErrKeyNotFound
+		}
+		if indexRec.expiresAt == 0 {
+			segmentstore.mu.RUnlock()
+			return nil
+		}
+		value, err := segmentstore.readValue(indexRec)
+		segmentstore.mu.RUnlock()
+		if err != nil {
+			return err
+		}
+
+		err = segmentstore.submitIf(CreateNewRecord(key, value, RegularRecord), func() error {
+			if segmentstore.index.Get(key) != indexRec {
+				return errKeyChanged
+			}
+			if indexRec.expired(uint64(time.Now().UnixNano())) {
+				return bitcask_errors.ErrKeyNotFound
+			}
+			return nil
+		})
+		// Start over if the key was written since its value was read
+		if err != errKeyChanged {
+			return err
+		}
+	}
 }
 
 func (segmentstore *SegmentStore) Delete(key []byte) (bool, error) {
+	if segmentstore.config.ReadOnly {
+		return false, bitcask_errors.ErrReadOnly
+	}
+
 	segmentstore.mu.RLock()
-	indexRec := segmentstore.index.Get(key)
+	indexRec := getLive(segmentstore.index, key)
 	segmentstore.mu.RUnlock()
 	if indexRec == nil {
 		return false, bitcask_errors.ErrKeyNotFound
 	}
 
 	tombStoneRecord := CreateNewRecord(key, nil, TombstoneRecord)
-	recordHeaderBuf := GetEncodedRecordHeader(tombStoneRecord)
-	walRecordHeaderBuf := GetWalRecordHeader(recordHeaderBuf, tombStoneRecord)
-
-	segmentstore.mu.Lock()
-	defer segmentstore.mu.Unlock()
-
-	if tombStoneRecord.WriteSize()+uint64(len(walRecordHeaderBuf)) > uint64(segmentstore.config.SegmentSize-segmentstore.activeSegment.curSize) {
-		segmentstore.OpenNewSegmentFile()
-	}
-	if _, _, error := segmentstore.storeRecord(walRecordHeaderBuf, recordHeaderBuf, tombStoneRecord); error != nil {
+	if error := segmentstore.submit(tombStoneRecord); error != nil {
 		return false, error
 	}
 
-	segmentstore.index.Delete(key)
-
 	return true, nil
 }
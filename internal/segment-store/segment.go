@@ -1,14 +1,17 @@
 package segmentstore
 
 import (
+	"cmp"
 	"encoding/binary"
 	"fmt"
 	"hash/crc32"
 	"io"
 	"os"
 	"path/filepath"
+	"time"
 
 	bitcask_errors "github.com/nitin-goyal19/bitcask/errors"
+	"github.com/nitin-goyal19/bitcask/internal/utils"
 )
 
 type SegmentId = int64
@@ -21,13 +24,23 @@ const WalRecordHeaderSize = 4 + 8
 type Segment struct {
 	id        SegmentId
 	fd        *os.File
+	header    *SegmentHeader
+	dataStart SegmentOffset // offset of the first WAL frame, right after the header
 	curOffset SegmentOffset
 	curSize   int64
 	isActive  bool
+	hints     []hintEntry // hint entries of records written since the segment was created
+	writeErr  error       // set when a failed write could not be undone, after which the segment takes no more writes
 }
 
+func segmentFileName(segmentId SegmentId) string {
+	return fmt.Sprintf("%d", segmentId)
+}
+
+// OpenSegment opens a segment file for reading, after checking that its header is
+// valid and belongs to the segment.
 func OpenSegment(dirPath string, segmentId SegmentId) (*Segment, error) {
-	file, err := os.Open(filepath.Join(dirPath, fmt.Sprintf("%d", segmentId)))
+	file, err := os.Open(filepath.Join(dirPath, segmentFileName(segmentId)))
 	if err != nil {
 		return nil, err
 	}
@@ -39,25 +52,62 @@ func OpenSegment(dirPath string, segmentId SegmentId) (*Segment, error) {
 		return nil, err
 	}
 
+	header, err := ReadSegmentHeader(file, fileInfo.Size())
+	if err == nil && header.Version > 0 && header.SegmentId != segmentId {
+		err = fmt.Errorf("%w: header is of segment %d", bitcask_errors.ErrInvalidSegmentHeader, header.SegmentId)
+	}
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("segment %d: %w", segmentId, err)
+	}
+
 	return &Segment{
 		id:        segmentId,
 		fd:        file,
+		header:    header,
+		dataStart: header.DataStart(),
 		curOffset: 0,
 		curSize:   fileInfo.Size(),
 		isActive:  false,
 	}, nil
 }
 
+// CreateNewSegment creates the file of a new segment, with its header, in dirPath.
+// The header is written to a temporary file which is renamed once the header is
+// durable, so a segment file never has a partially written header.
 func CreateNewSegment(dirPath string, segmentId SegmentId) (*Segment, error) {
-	file, err := os.OpenFile(filepath.Join(dirPath, fmt.Sprintf("%d", segmentId)), os.O_CREATE|os.O_RDWR|os.O_APPEND, 0644)
+	segmentFilePath := filepath.Join(dirPath, segmentFileName(segmentId))
+	if _, err := os.Stat(segmentFilePath); !os.IsNotExist(err) {
+		return nil, fmt.Errorf("can not create segment %d: %w", segmentId, cmp.Or(err, os.ErrExist))
+	}
+	tmpFilePath := segmentFilePath + ".tmp"
+	file, err := os.OpenFile(tmpFilePath, os.O_CREATE|os.O_TRUNC|os.O_RDWR|os.O_APPEND, 0644)
 
 	if err != nil {
 		return nil, err
 	}
 
-	fileInfo, err := file.Stat()
-
-	if err != nil {
+	header := &SegmentHeader{
+		Version:   SegmentFormatVersion,
+		SegmentId: segmentId,
+		CreatedAt: time.Now(),
+	}
+	if _, err := file.Write(encodeSegmentHeader(header)); err != nil {
+		file.Close()
+		os.Remove(tmpFilePath)
+		return nil, err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		os.Remove(tmpFilePath)
+		return nil, err
+	}
+	if err := os.Rename(tmpFilePath, segmentFilePath); err != nil {
+		file.Close()
+		os.Remove(tmpFilePath)
+		return nil, err
+	}
+	if err := utils.SyncDir(dirPath); err != nil {
 		file.Close()
 		return nil, err
 	}
@@ -65,15 +115,50 @@ func CreateNewSegment(dirPath string, segmentId SegmentId) (*Segment, error) {
 	return &Segment{
 		id:        segmentId,
 		fd:        file,
-		curOffset: 0,
-		curSize:   fileInfo.Size(),
+		header:    header,
+		dataStart: header.DataStart(),
+		curOffset: SegmentHeaderSize,
+		curSize:   SegmentHeaderSize,
 		isActive:  true,
 	}, nil
 }
 
+// reopenForAppend makes a segment opened by OpenSegment writable again, so that
+// records can be appended to it. Its hint file is removed, as it would not describe
+// the segment anymore after the first append.
+func (segment *Segment) reopenForAppend(dirPath string) error {
+	fileInfo, err := segment.fd.Stat()
+	if err != nil {
+		return err
+	}
+	if fileInfo.Size() != segment.curSize {
+		return fmt.Errorf("segment file has %d bytes, of which only %d could be read", fileInfo.Size(), segment.curSize)
+	}
+
+	file, err := os.OpenFile(filepath.Join(dirPath, segmentFileName(segment.id)), os.O_RDWR|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(filepath.Join(dirPath, hintFileName(segment.id))); err != nil && !os.IsNotExist(err) {
+		file.Close()
+		return err
+	}
+
+	segment.fd.Close()
+	segment.fd = file
+	segment.curOffset = uint64(segment.curSize)
+	segment.isActive = true
+	return nil
+}
+
+// isEmpty tells whether no record has been written to the segment.
+func (segment *Segment) isEmpty() bool {
+	return uint64(segment.curSize) <= segment.dataStart
+}
+
 func GetWalRecordHeader(recordHeaderBuf []byte, record *Record) []byte {
 	walRecordHeader := make([]byte, WalRecordHeaderSize)
-	var recordSize uint64 = uint64(RecordHeaderSize + len(record.Key) + len(record.Val))
+	var recordSize uint64 = record.WriteSize()
 	binary.Encode(walRecordHeader[4:], binary.BigEndian, recordSize)
 
 	crcSum := crc32.ChecksumIEEE(walRecordHeader[4:])
@@ -86,6 +171,105 @@ func GetWalRecordHeader(recordHeaderBuf []byte, record *Record) []byte {
 	return walRecordHeader
 }
 
+// seal flushes the segment to disk and closes it for further writes.
+func (segment *Segment) seal() error {
+	segment.isActive = false
+	return segment.fd.Sync()
+}
+
+// writeHintFile writes the hint entries collected while writing to the segment
+// to its hint file in dirPath.
+func (segment *Segment) writeHintFile(dirPath string) error {
+	hints := segment.hints
+	segment.hints = nil
+	return writeHintFile(dirPath, segment.id, segment.curSize, hints)
+}
+
+// scanHintEntries builds the hint entries of the segment by reading all of its records.
+// On error, it also returns the entries read till then and the offset of the first
+// record which could not be read.
+func (segment *Segment) scanHintEntries() ([]hintEntry, SegmentOffset, error) {
+	var entries []hintEntry
+	offset := segment.dataStart
+	for {
+		recordBuf, _, numBytesRead, err := segment.ReadEncodeRecordWithCrcCheck(offset)
+		if err != nil {
+			return entries, offset, err
+		}
+		if numBytesRead == 0 {
+			break
+		}
+
+		record, err := GetDecodedRecord(recordBuf)
+		if err != nil {
+			return entries, offset, fmt.Errorf("%w: %w", bitcask_errors.ErrCrcVerificationFailed, err)
+		}
+
+		entries = appendHintEntries(entries, record, offset)
+		offset += numBytesRead
+	}
+	return entries, offset, nil
+}
+
+// truncate cuts the segment file short at size.
+func (segment *Segment) truncate(dirPath string, size int64) error {
+	if err := os.Truncate(filepath.Join(dirPath, segmentFileName(segment.id)), size); err != nil {
+		return err
+	}
+	segment.curSize = size
+	segment.curOffset = uint64(size)
+	return nil
+}
+
+// appendFrame appends the WAL frame of a record, i.e. its WAL record header, record
+// header, key and value, to buf.
+func appendFrame(buf, walRecordHeaderBuf, recordHeaderBuf []byte, record *Record) []byte {
+	buf = append(buf, walRecordHeaderBuf...)
+	buf = append(buf, recordHeaderBuf...)
+	buf = append(buf, record.Key...)
+	return append(buf, record.Val...)
+}
+
+// Append writes frames to the end of the segment with a single write, so that a
+// frame never gets interleaved with a partial write of another one, and returns
+// the offset at which they were written.
+func (segment *Segment) Append(frames []byte) (SegmentOffset, error) {
+	if !segment.isActive {
+		return 0, bitcask_errors.ErrSegmentClosedForWrite
+	}
+	if segment.writeErr != nil {
+		return 0, fmt.Errorf("%w: %w", bitcask_errors.ErrSegmentClosedForWrite, segment.writeErr)
+	}
+
+	numBytesWritten, err := segment.fd.Write(frames)
+	if err != nil {
+		// A partial write would shift the offsets of every frame written after it
+		if numBytesWritten > 0 {
+			if truncateErr := segment.fd.Truncate(int64(segment.curOffset)); truncateErr != nil {
+				segment.writeErr = fmt.Errorf("undoing a partial write of segment %d: %w", segment.id, truncateErr)
+			}
+		}
+		return 0, err
+	}
+
+	offset := segment.curOffset
+	segment.curOffset += uint64(numBytesWritten)
+	segment.curSize += int64(numBytesWritten)
+	return offset, nil
+}
+
+// addHints remembers the hint entries of the record written to the segment at
+// frameOffset, and returns them.
+func (segment *Segment) addHints(record *Record, frameOffset SegmentOffset) []hintEntry {
+	numHints := len(segment.hints)
+	segment.hints = appendHintEntries(segment.hints, record, frameOffset)
+	return segment.hints[numHints:]
+}
+
+func (segment *Segment) Sync() error {
+	return segment.fd.Sync()
+}
+
 func (segment *Segment) Close() error {
 	return segment.fd.Close()
 }
@@ -105,41 +289,14 @@ func (segment *Segment) Write(walRecordHeaderBuf, recordHeaderBuf []byte, record
 
 	// binary.Encode(walRecordHeader, binary.BigEndian, crcSum)
 
-	totalBytesWritten := 0
-	numBytesWritten, err := segment.fd.Write(walRecordHeaderBuf)
-
-	if err != nil {
-		return 0, 0, err
-	}
-	totalBytesWritten += numBytesWritten
-
-	numBytesWritten, err = segment.fd.Write(recordHeaderBuf)
+	recordOffset, err := segment.Append(appendFrame(nil, walRecordHeaderBuf, recordHeaderBuf, record))
 
 	if err != nil {
 		return 0, 0, err
 	}
-	totalBytesWritten += numBytesWritten
 
-	numBytesWritten, err = segment.fd.Write(record.Key)
-
-	if err != nil {
-		return 0, 0, err
-	}
-	totalBytesWritten += numBytesWritten
-
-	numBytesWritten, err = segment.fd.Write(record.Val)
-
-	if err != nil {
-		return 0, 0, err
-	}
-	totalBytesWritten += numBytesWritten
-
-	// log.Printf("num bytes written: %d", totalBytesWritten)
-
-	recordOffset := segment.curOffset
-	valOffset := uint64(segment.curOffset + uint64(totalBytesWritten-numBytesWritten))
-	segment.curOffset += uint64(totalBytesWritten)
-	segment.curSize += int64(totalBytesWritten)
+	valOffset := recordOffset + WalRecordHeaderSize + record.ValOffset()
+	segment.addHints(record, recordOffset)
 
 	// walRecordSize := uint64(WalRecordHeaderSize) + recordSize
 
@@ -158,16 +315,63 @@ func (segment *Segment) Read(offset SegmentOffset, valSize uint64) ([]byte, erro
 	return readBytes, nil
 }
 
-func (segment *Segment) ReadEncodeRecordWithCrcCheck(offset SegmentOffset) ([]byte, uint64, uint64, error) {
-	walHeader, error := segment.Read(offset, WalRecordHeaderSize)
+// ReadVerifiedValue is Read which reads the whole WAL frame at frameOffset holding the
+// value, and checks its CRC before returning the value.
+func (segment *Segment) ReadVerifiedValue(frameOffset, valueOffset SegmentOffset, valSize uint64) ([]byte, error) {
+	walHeader := make([]byte, WalRecordHeaderSize)
+	if _, err := segment.fd.ReadAt(walHeader, int64(frameOffset)); err != nil {
+		return nil, segment.frameError(frameOffset, err)
+	}
+	storedCrcSum := binary.BigEndian.Uint32(walHeader[0:4])
+	recordLen := binary.BigEndian.Uint64(walHeader[4:])
 
-	if error != nil && (error != io.EOF || len(walHeader) != 0) {
-		return nil, 0, 0, error
+	recordOffset := frameOffset + WalRecordHeaderSize
+	// The length is checked before the record is read into a buffer of that size
+	if recordOffset > uint64(segment.curSize) || recordLen > uint64(segment.curSize)-recordOffset {
+		return nil, segment.frameError(frameOffset, fmt.Errorf("%w: record of %d bytes runs past the end of the segment", bitcask_errors.ErrIncompleteRecord, recordLen))
+	}
+	if valueOffset < recordOffset || recordLen < RecordHeaderSize || valueOffset-recordOffset+valSize > recordLen {
+		return nil, segment.frameError(frameOffset, fmt.Errorf("%w: record of %d bytes does not hold the value", bitcask_errors.ErrCrcVerificationFailed, recordLen))
 	}
 
-	if error == io.EOF {
+	recordBuf := make([]byte, recordLen)
+	if _, err := segment.fd.ReadAt(recordBuf, int64(recordOffset)); err != nil {
+		return nil, segment.frameError(frameOffset, err)
+	}
+	crcSum := crc32.Update(crc32.ChecksumIEEE(walHeader[4:]), crc32.IEEETable, recordBuf)
+	if crcSum != storedCrcSum {
+		return nil, segment.frameError(frameOffset, fmt.Errorf("%w: computed CRC is %08x", bitcask_errors.ErrCrcVerificationFailed, crcSum))
+	}
+
+	valueStart := valueOffset - recordOffset
+	return recordBuf[valueStart : valueStart+valSize], nil
+}
+
+// frameError names the segment and the offset of the WAL frame in an error reading the frame.
+func (segment *Segment) frameError(frameOffset SegmentOffset, err error) error {
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		err = bitcask_errors.ErrIncompleteRecord
+	}
+	return fmt.Errorf("segment %d at offset %d: %w", segment.id, frameOffset, err)
+}
+
+// ReadEncodeRecordWithCrcCheck reads the WAL frame at offset and returns the encoded
+// record in it, the offset of the record and the size of the frame. The size is 0
+// when offset is the end of the segment. ErrIncompleteRecord is returned when the
+// segment ends in the middle of the frame.
+func (segment *Segment) ReadEncodeRecordWithCrcCheck(offset SegmentOffset) ([]byte, uint64, uint64, error) {
+	if offset >= uint64(segment.curSize) {
 		return nil, 0, 0, nil
 	}
+	if uint64(segment.curSize)-offset < WalRecordHeaderSize {
+		return nil, 0, 0, bitcask_errors.ErrIncompleteRecord
+	}
+
+	walHeader, error := segment.Read(offset, WalRecordHeaderSize)
+
+	if error != nil {
+		return nil, 0, 0, error
+	}
 
 	var storedCrcSum uint32
 	if _, error = binary.Decode(walHeader[0:4], binary.BigEndian, &storedCrcSum); error != nil {
@@ -179,6 +383,13 @@ func (segment *Segment) ReadEncodeRecordWithCrcCheck(offset SegmentOffset) ([]by
 	}
 
 	recordOffset := offset + uint64(WalRecordHeaderSize)
+	if recordLen > uint64(segment.curSize)-recordOffset {
+		return nil, 0, 0, bitcask_errors.ErrIncompleteRecord
+	}
+	if recordLen < RecordHeaderSize {
+		return nil, 0, 0, bitcask_errors.ErrCrcVerificationFailed
+	}
+
 	recordBuf, error := segment.Read(recordOffset, recordLen)
 
 	if error != nil {
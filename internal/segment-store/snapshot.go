@@ -0,0 +1,223 @@
+package segmentstore
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+
+	bitcask_errors "github.com/nitin-goyal19/bitcask/errors"
+)
+
+// Suffix of segment files merged away while a snapshot was still reading from them.
+const retiredFileSuffix = ".retired"
+
+func retiredFileName(segmentId SegmentId) string {
+	return segmentFileName(segmentId) + retiredFileSuffix
+}
+
+// Snapshot is a read-only view of the store as it was when the snapshot was taken.
+// The segments it reads from are pinned till it is closed, so that they are not
+// deleted by merges.
+type Snapshot struct {
+	segStore   *SegmentStore
+	index      Index
+	segmentIds []SegmentId
+	mu         sync.RWMutex
+	closed     bool
+}
+
+// Snapshot returns a snapshot of the store. It must be closed once it is not needed
+// anymore, as the segments it pins take up disk space.
+func (segStore *SegmentStore) Snapshot() *Snapshot {
+	segStore.mu.RLock()
+	entries := segStore.index.Entries()
+	segmentIds := make([]SegmentId, 0, len(segStore.oldSegments)+1)
+	if segStore.activeSegment != nil {
+		segmentIds = append(segmentIds, segStore.activeSegment.id)
+	}
+	for segmentId := range segStore.oldSegments {
+		segmentIds = append(segmentIds, segmentId)
+	}
+	// Merges remove segments with mu held, so every segment is still there when pinned
+	segStore.pinSegments(segmentIds)
+	segStore.mu.RUnlock()
+
+	index := CreateIndex(segStore.config.IndexType)
+	for _, entry := range entries {
+		index.Set(entry.Key, entry.indexRec)
+	}
+
+	return &Snapshot{
+		segStore:   segStore,
+		index:      index,
+		segmentIds: segmentIds,
+	}
+}
+
+func (segStore *SegmentStore) pinSegments(segmentIds []SegmentId) {
+	segStore.pinMu.Lock()
+	defer segStore.pinMu.Unlock()
+	for _, segmentId := range segmentIds {
+		segStore.pins[segmentId]++
+	}
+}
+
+// unpinSegments releases the pins of a snapshot, deleting the segments which have
+// been merged away and are not pinned by any other snapshot.
+func (segStore *SegmentStore) unpinSegments(segmentIds []SegmentId) error {
+	segStore.pinMu.Lock()
+	defer segStore.pinMu.Unlock()
+
+	var firstErr error
+	for _, segmentId := range segmentIds {
+		segStore.pins[segmentId]--
+		if segStore.pins[segmentId] > 0 {
+			continue
+		}
+		delete(segStore.pins, segmentId)
+
+		if segment, ok := segStore.retiredSegments[segmentId]; ok {
+			delete(segStore.retiredSegments, segmentId)
+			segment.Close()
+			// Segments retired while the store was open read-only were not renamed
+			if err := os.Remove(filepath.Join(segStore.segmentDirPath(), retiredFileName(segmentId))); err != nil && !os.IsNotExist(err) && firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+	return firstErr
+}
+
+// retireSegment deletes a segment which has been merged away, or, if snapshots are
+// still reading from it, renames it so that it is not replayed when the store is
+// opened and leaves its deletion to the last of them. While the store is open
+// read-only, the segment is left as is, to be deleted once it is not anymore. It
+// must be called with mu held.
+func (segStore *SegmentStore) retireSegment(segment *Segment) error {
+	for location := range segStore.corruptRecords {
+		if location.segmentId == segment.id {
+			delete(segStore.corruptRecords, location)
+		}
+	}
+
+	unlock, err := segStore.lockOutReaders()
+	if err != nil {
+		return err
+	}
+	if unlock == nil {
+		// A read-only open may be about to open the segment file, so it is left in place
+		// till the store is not open read-only anymore
+		segStore.pinMu.Lock()
+		defer segStore.pinMu.Unlock()
+		if segStore.pins[segment.id] == 0 {
+			segment.Close()
+		} else {
+			segStore.retiredSegments[segment.id] = segment
+		}
+		segStore.mergedAwaySegmentIds = append(segStore.mergedAwaySegmentIds, segment.id)
+		return nil
+	}
+	defer unlock()
+
+	segStore.pinMu.Lock()
+	defer segStore.pinMu.Unlock()
+
+	if segStore.pins[segment.id] == 0 {
+		segment.Close()
+		return removeSegmentFiles(segStore.segmentDirPath(), segment.id)
+	}
+
+	dirPath := segStore.segmentDirPath()
+	if err := os.Rename(filepath.Join(dirPath, segmentFileName(segment.id)), filepath.Join(dirPath, retiredFileName(segment.id))); err != nil {
+		return err
+	}
+	if err := os.Remove(filepath.Join(dirPath, hintFileName(segment.id))); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	segStore.retiredSegments[segment.id] = segment
+	return nil
+}
+
+// pinnedSegment returns the segment with segmentId, which must be pinned, whether or
+// not it has been merged away since it was pinned.
+func (segStore *SegmentStore) pinnedSegment(segmentId SegmentId) *Segment {
+	segStore.mu.RLock()
+	segment := segStore.getSegment(segmentId)
+	segStore.mu.RUnlock()
+	if segment != nil {
+		return segment
+	}
+
+	segStore.pinMu.Lock()
+	defer segStore.pinMu.Unlock()
+	return segStore.retiredSegments[segmentId]
+}
+
+func (snapshot *Snapshot) readValue(indexRec *IndexRecord) ([]byte, error) {
+	segStore := snapshot.segStore
+	// Held while reading, as the value may be in the active segment, whose size
+	// changes with mu held
+	segStore.mu.RLock()
+	defer segStore.mu.RUnlock()
+	if err := segStore.corruptRecordErr(indexRec); err != nil {
+		return nil, err
+	}
+	segment := segStore.getSegment(indexRec.segmentId)
+	if segment == nil {
+		segStore.pinMu.Lock()
+		segment = segStore.retiredSegments[indexRec.segmentId]
+		segStore.pinMu.Unlock()
+	}
+	if segment == nil {
+		return nil, bitcask_errors.ErrDbClosed
+	}
+	return segStore.readSegmentValue(segment, indexRec)
+}
+
+func (snapshot *Snapshot) Read(key []byte) ([]byte, error) {
+	snapshot.mu.RLock()
+	defer snapshot.mu.RUnlock()
+	if snapshot.closed {
+		return nil, bitcask_errors.ErrSnapshotClosed
+	}
+
+	indexRec := getLive(snapshot.index, key)
+	if indexRec == nil {
+		return nil, bitcask_errors.ErrKeyNotFound
+	}
+	return snapshot.readValue(indexRec)
+}
+
+// ReadEntry reads the value of an entry returned by Entries or RangeEntries of the snapshot.
+func (snapshot *Snapshot) ReadEntry(entry IndexEntry) ([]byte, error) {
+	snapshot.mu.RLock()
+	defer snapshot.mu.RUnlock()
+	if snapshot.closed {
+		return nil, bitcask_errors.ErrSnapshotClosed
+	}
+	return snapshot.readValue(entry.indexRec)
+}
+
+func (snapshot *Snapshot) Entries() []IndexEntry {
+	return liveEntries(snapshot.index.Entries())
+}
+
+func (snapshot *Snapshot) RangeEntries(start, end []byte, reverse bool) []IndexEntry {
+	return liveEntries(snapshot.index.Range(start, end, reverse))
+}
+
+// Len returns the number of keys in the snapshot, counting those whose values have expired.
+func (snapshot *Snapshot) Len() int {
+	return snapshot.index.Len()
+}
+
+// Close releases the segments pinned by the snapshot. Closing a closed snapshot does nothing.
+func (snapshot *Snapshot) Close() error {
+	snapshot.mu.Lock()
+	defer snapshot.mu.Unlock()
+	if snapshot.closed {
+		return nil
+	}
+	snapshot.closed = true
+	return snapshot.segStore.unpinSegments(snapshot.segmentIds)
+}
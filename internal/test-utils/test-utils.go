@@ -2,6 +2,9 @@ package testutils
 
 import (
 	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
 )
 
 var (
@@ -45,6 +48,25 @@ func GenerateCorpus(n, maxKeySize, maxValueSize int, seed int64) KVCorpus {
 	return corpus
 }
 
+// DirSize returns the total size of the files directly inside dirPath.
+func DirSize(t testing.TB, dirPath string) int64 {
+	t.Helper()
+	entries, err := os.ReadDir(dirPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var size int64
+	for _, entry := range entries {
+		info, err := os.Stat(filepath.Join(dirPath, entry.Name()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		size += info.Size()
+	}
+	return size
+}
+
 func max(a, b int) int {
 	if a > b {
 		return a
@@ -13,3 +13,14 @@ func DirExists(dirPath string) (bool, error) {
 
 	return true, nil
 }
+
+// SyncDir flushes the directory entries of dirPath to disk, so that files created
+// in or renamed into it survive a crash.
+func SyncDir(dirPath string) error {
+	dir, err := os.Open(dirPath)
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+	return dir.Sync()
+}
@@ -0,0 +1,67 @@
+package utils
+
+import "os"
+
+// FileLock is an advisory lock held on a file till Unlock is called.
+type FileLock struct {
+	file *os.File // nil for a shared lock on a file which does not exist
+}
+
+// LockFile takes an exclusive advisory lock on the file at path, creating the file
+// if it does not exist. It fails with ErrDatabaseLocked if the lock is held by
+// another process or by another open file of this process.
+func LockFile(path string) (*FileLock, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := lockFile(file, true, false); err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	return &FileLock{file: file}, nil
+}
+
+// LockFileShared takes a shared advisory lock on the file at path, which can be held
+// by any number of readers at once. It fails with ErrDatabaseLocked if an exclusive
+// lock is held on the file. The file is not created if it does not exist, in which
+// case there is nothing to lock and the returned lock does not hold anything.
+func LockFileShared(path string) (*FileLock, error) {
+	return lockFileShared(path, false)
+}
+
+// WaitLockFileShared is LockFileShared which waits for an exclusive lock held on the
+// file to be released rather than failing.
+func WaitLockFileShared(path string) (*FileLock, error) {
+	return lockFileShared(path, true)
+}
+
+func lockFileShared(path string, wait bool) (*FileLock, error) {
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return &FileLock{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := lockFile(file, false, wait); err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	return &FileLock{file: file}, nil
+}
+
+func (lock *FileLock) Unlock() error {
+	if lock.file == nil {
+		return nil
+	}
+	if err := unlockFile(lock.file); err != nil {
+		lock.file.Close()
+		return err
+	}
+	return lock.file.Close()
+}
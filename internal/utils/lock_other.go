@@ -0,0 +1,16 @@
+//go:build !unix
+
+package utils
+
+import "os"
+
+// Advisory file locks are only supported on unix systems. Elsewhere the lock is
+// not enforced.
+
+func lockFile(file *os.File, exclusive, wait bool) error {
+	return nil
+}
+
+func unlockFile(file *os.File) error {
+	return nil
+}
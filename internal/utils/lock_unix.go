@@ -0,0 +1,30 @@
+//go:build unix
+
+package utils
+
+import (
+	"errors"
+	"os"
+	"syscall"
+
+	bitcask_errors "github.com/nitin-goyal19/bitcask/errors"
+)
+
+func lockFile(file *os.File, exclusive, wait bool) error {
+	how := syscall.LOCK_SH
+	if exclusive {
+		how = syscall.LOCK_EX
+	}
+	if !wait {
+		how |= syscall.LOCK_NB
+	}
+	err := syscall.Flock(int(file.Fd()), how)
+	if errors.Is(err, syscall.EWOULDBLOCK) {
+		return bitcask_errors.ErrDatabaseLocked
+	}
+	return err
+}
+
+func unlockFile(file *os.File) error {
+	return syscall.Flock(int(file.Fd()), syscall.LOCK_UN)
+}
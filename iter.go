@@ -0,0 +1,97 @@
+package bitcask
+
+import (
+	"errors"
+	"iter"
+
+	bitcask_errors "github.com/nitin-goyal19/bitcask/errors"
+	segmentstore "github.com/nitin-goyal19/bitcask/internal/segment-store"
+)
+
+// Keys returns an iterator over the keys present in the DB when Keys is called.
+// Writes made while iterating do not change the keys it yields.
+func (db *Bitcask) Keys() iter.Seq[[]byte] {
+	entries := db.segmentStore.Entries()
+	return func(yield func([]byte) bool) {
+		for _, entry := range entries {
+			if !yield(entry.Key) {
+				return
+			}
+		}
+	}
+}
+
+// ForEach calls fn with every key present in the DB when ForEach is called, along
+// with its value. Keys deleted while iterating are skipped. It stops at the first
+// error returned by fn or hit while reading a value, and returns it.
+func (db *Bitcask) ForEach(fn func(key, value []byte) error) error {
+	return forEachEntry(db.segmentStore, db.segmentStore.Entries(), fn)
+}
+
+// All returns an iterator over the keys present in the DB when All is called, along
+// with their values. Iteration ends early if a value can not be read; use ForEach
+// to get hold of such errors.
+func (db *Bitcask) All() iter.Seq2[[]byte, []byte] {
+	return iterateEntries(db.segmentStore, db.segmentStore.Entries())
+}
+
+// Scan returns an iterator over the keys having prefix, along with their values, in
+// key order or in reverse key order. Like All, it iterates over the keys present when
+// Scan is called. Scans are cheapest with config.OrderedIndex; with config.HashIndex
+// every key of the DB is looked at and the matching ones are sorted.
+func (db *Bitcask) Scan(prefix []byte, reverse bool) iter.Seq2[[]byte, []byte] {
+	return db.Range(prefix, segmentstore.PrefixEnd(prefix), reverse)
+}
+
+// Range returns an iterator over the keys from start, inclusive, till end, exclusive,
+// along with their values, in key order or in reverse key order. A nil start or end
+// leaves that side of the range open. Like All, it iterates over the keys present
+// when Range is called.
+func (db *Bitcask) Range(start, end []byte, reverse bool) iter.Seq2[[]byte, []byte] {
+	return iterateEntries(db.segmentStore, db.segmentStore.RangeEntries(start, end, reverse))
+}
+
+// Fold calls fn with every key present in the DB when Fold is called, its value and
+// the accumulator returned by the previous call, starting with acc, and returns the
+// accumulator returned by the last call. It stops at the first error.
+func Fold[T any](db *Bitcask, fn func(key, value []byte, acc T) (T, error), acc T) (T, error) {
+	err := db.ForEach(func(key, value []byte) error {
+		var err error
+		acc, err = fn(key, value, acc)
+		return err
+	})
+	return acc, err
+}
+
+// entryReader reads the values of index entries, of either the DB or a snapshot of it.
+type entryReader interface {
+	ReadEntry(entry segmentstore.IndexEntry) ([]byte, error)
+}
+
+func iterateEntries(reader entryReader, entries []segmentstore.IndexEntry) iter.Seq2[[]byte, []byte] {
+	return func(yield func([]byte, []byte) bool) {
+		errStop := errors.New("stop iteration")
+		forEachEntry(reader, entries, func(key, value []byte) error {
+			if !yield(key, value) {
+				return errStop
+			}
+			return nil
+		})
+	}
+}
+
+func forEachEntry(reader entryReader, entries []segmentstore.IndexEntry, fn func(key, value []byte) error) error {
+	for _, entry := range entries {
+		value, err := reader.ReadEntry(entry)
+		if errors.Is(err, bitcask_errors.ErrKeyNotFound) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if err := fn(entry.Key, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
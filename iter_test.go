@@ -0,0 +1,198 @@
+package bitcask
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"math/rand"
+	"slices"
+	"sync"
+	"testing"
+
+	"github.com/nitin-goyal19/bitcask/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func openWithKeys(t *testing.T, numKeys int) (*Bitcask, map[string][]byte) {
+	return openWithKeysIn(t, t.TempDir(), numKeys)
+}
+
+func openWithKeysIn(t *testing.T, dataDirectory string, numKeys int) (*Bitcask, map[string][]byte) {
+	db, error := Open("test-db", &config.Config{
+		DataDirectory: dataDirectory,
+		SegmentSize:   16 * config.KB,
+	})
+
+	assert.Nil(t, error)
+
+	keyValMap := make(map[string][]byte)
+	for i := 0; i < numKeys; i++ {
+		key := fmt.Sprintf("key-%03d", i)
+		val := []byte(fmt.Sprintf("val-%03d", i))
+		assert.Nil(t, db.Set([]byte(key), val))
+		keyValMap[key] = val
+	}
+	return db, keyValMap
+}
+
+func TestKeys(t *testing.T) {
+	db, keyValMap := openWithKeys(t, 100)
+	defer db.Close()
+
+	keys := db.Keys()
+
+	// Writes made after Keys is called are not seen
+	assert.Nil(t, db.Set([]byte("new-key"), []byte("val")))
+
+	var storedKeys []string
+	for key := range keys {
+		storedKeys = append(storedKeys, string(key))
+	}
+
+	var expectedKeys []string
+	for key := range keyValMap {
+		expectedKeys = append(expectedKeys, key)
+	}
+	assert.ElementsMatch(t, expectedKeys, storedKeys)
+}
+
+func TestForEachAndAll(t *testing.T) {
+	db, keyValMap := openWithKeys(t, 100)
+	defer db.Close()
+
+	storedKeyValMap := make(map[string][]byte)
+	err := db.ForEach(func(key, value []byte) error {
+		storedKeyValMap[string(key)] = value
+		return nil
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, keyValMap, storedKeyValMap)
+
+	clear(storedKeyValMap)
+	for key, value := range db.All() {
+		storedKeyValMap[string(key)] = value
+	}
+	assert.Equal(t, keyValMap, storedKeyValMap)
+
+	errStop := errors.New("stop")
+	numCalls := 0
+	err = db.ForEach(func(key, value []byte) error {
+		numCalls++
+		return errStop
+	})
+	assert.ErrorIs(t, err, errStop)
+	assert.Equal(t, 1, numCalls)
+}
+
+func TestFold(t *testing.T) {
+	db, keyValMap := openWithKeys(t, 100)
+	defer db.Close()
+
+	totalSize, err := Fold(db, func(key, value []byte, acc int) (int, error) {
+		return acc + len(value), nil
+	}, 0)
+	assert.Nil(t, err)
+
+	expectedSize := 0
+	for _, val := range keyValMap {
+		expectedSize += len(val)
+	}
+	assert.Equal(t, expectedSize, totalSize)
+}
+
+func TestForEachWithConcurrentWrites(t *testing.T) {
+	db, keyValMap := openWithKeys(t, 500)
+	defer db.Close()
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 500; i++ {
+			assert.Nil(t, db.Set([]byte(fmt.Sprintf("key-%03d", i)), []byte("overwritten")))
+			assert.Nil(t, db.Set([]byte(fmt.Sprintf("other-key-%03d", i)), []byte("val")))
+		}
+		assert.Nil(t, db.Merge())
+	}()
+
+	seenKeys := make(map[string]bool)
+	err := db.ForEach(func(key, value []byte) error {
+		seenKeys[string(key)] = true
+		return nil
+	})
+	wg.Wait()
+
+	assert.Nil(t, err)
+	for key := range keyValMap {
+		assert.True(t, seenKeys[key])
+	}
+}
+
+func TestScanAndRange(t *testing.T) {
+	for _, indexType := range []config.IndexType{config.HashIndex, config.OrderedIndex} {
+		t.Run(fmt.Sprintf("index type %d", indexType), func(t *testing.T) {
+			db, error := Open("test-db", &config.Config{
+				DataDirectory: t.TempDir(),
+				IndexType:     indexType,
+			})
+
+			assert.Nil(t, error)
+
+			defer db.Close()
+
+			for _, key := range []string{"b", "a", "ab", "abc", "b\xff", "ac", "c", "\xff\xff"} {
+				assert.Nil(t, db.Set([]byte(key), []byte("val-"+key)))
+			}
+			_, error = db.Delete([]byte("ac"))
+			assert.Nil(t, error)
+
+			collect := func(seq func(func([]byte, []byte) bool)) []string {
+				var keys []string
+				for key, value := range seq {
+					assert.Equal(t, "val-"+string(key), string(value))
+					keys = append(keys, string(key))
+				}
+				return keys
+			}
+
+			assert.Equal(t, []string{"a", "ab", "abc"}, collect(db.Scan([]byte("a"), false)))
+			assert.Equal(t, []string{"abc", "ab", "a"}, collect(db.Scan([]byte("a"), true)))
+			assert.Equal(t, []string{"b", "b\xff"}, collect(db.Scan([]byte("b"), false)))
+			assert.Equal(t, []string{"\xff\xff"}, collect(db.Scan([]byte("\xff"), false)))
+			assert.Equal(t, []string{"a", "ab", "abc", "b", "b\xff", "c", "\xff\xff"}, collect(db.Scan(nil, false)))
+			assert.Equal(t, []string{"ab", "abc", "b"}, collect(db.Range([]byte("ab"), []byte("b\xff"), false)))
+			assert.Equal(t, []string{"b", "abc", "ab", "a"}, collect(db.Range(nil, []byte("b\xff"), true)))
+			assert.Equal(t, []string{"b\xff", "c", "\xff\xff"}, collect(db.Range([]byte("bz"), nil, false)))
+		})
+	}
+}
+
+func TestOrderedIndexKeepsKeysSorted(t *testing.T) {
+	db, error := Open("test-db", &config.Config{
+		DataDirectory: t.TempDir(),
+		IndexType:     config.OrderedIndex,
+	})
+
+	assert.Nil(t, error)
+
+	defer db.Close()
+
+	keySet := make(map[string]bool)
+	for i := 0; i < 2000; i++ {
+		key := fmt.Sprintf("key-%d", rand.Intn(500))
+		if rand.Intn(3) == 0 {
+			db.Delete([]byte(key))
+			delete(keySet, key)
+		} else {
+			assert.Nil(t, db.Set([]byte(key), []byte(key)))
+			keySet[key] = true
+		}
+	}
+
+	expectedKeys := slices.Sorted(maps.Keys(keySet))
+	var storedKeys []string
+	for key := range db.Scan([]byte("key-"), false) {
+		storedKeys = append(storedKeys, string(key))
+	}
+	assert.Equal(t, expectedKeys, storedKeys)
+}
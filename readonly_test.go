@@ -0,0 +1,217 @@
+package bitcask
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/nitin-goyal19/bitcask/config"
+	bitcask_errors "github.com/nitin-goyal19/bitcask/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+// dirContents returns the contents of every file under dirPath by path.
+func dirContents(t *testing.T, dirPath string) map[string]string {
+	t.Helper()
+	contents := make(map[string]string)
+	assert.Nil(t, filepath.WalkDir(dirPath, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() {
+			return err
+		}
+		content, err := os.ReadFile(path)
+		contents[path] = string(content)
+		return err
+	}))
+	return contents
+}
+
+func TestReadOnly(t *testing.T) {
+	tempDir := t.TempDir()
+	cfg := &config.Config{
+		DataDirectory: tempDir,
+		SegmentSize:   1 * config.KB,
+	}
+
+	db, err := Open("test-db", cfg)
+
+	assert.Nil(t, err)
+
+	for i := 0; i < 100; i++ {
+		assert.Nil(t, db.Set([]byte(fmt.Sprintf("key-%d", i%20)), []byte(fmt.Sprintf("val-%d", i))))
+	}
+	_, err = db.Delete([]byte("key-0"))
+	assert.Nil(t, err)
+	db.Close()
+
+	contentsBefore := dirContents(t, tempDir)
+
+	readOnlyCfg := &config.Config{
+		DataDirectory: tempDir,
+		SegmentSize:   1 * config.KB,
+		ReadOnly:      true,
+	}
+	db1, err := Open("test-db", readOnlyCfg)
+
+	assert.Nil(t, err)
+
+	db2, err := Open("test-db", readOnlyCfg)
+
+	assert.Nil(t, err)
+
+	for _, db := range []*Bitcask{db1, db2} {
+		_, err := db.Get([]byte("key-0"))
+		assert.ErrorIs(t, err, bitcask_errors.ErrKeyNotFound)
+		for i := 81; i < 100; i++ {
+			val, err := db.Get([]byte(fmt.Sprintf("key-%d", i%20)))
+			assert.Nil(t, err)
+			assert.Equal(t, []byte(fmt.Sprintf("val-%d", i)), val)
+		}
+	}
+
+	snapshot := db1.Snapshot()
+	assert.Equal(t, 19, snapshot.Len())
+	assert.Nil(t, snapshot.Close())
+
+	assert.ErrorIs(t, db1.Set([]byte("key-1"), []byte("val")), bitcask_errors.ErrReadOnly)
+	_, err = db1.Delete([]byte("key-1"))
+	assert.ErrorIs(t, err, bitcask_errors.ErrReadOnly)
+	_, err = db1.Delete([]byte("missing-key"))
+	assert.ErrorIs(t, err, bitcask_errors.ErrReadOnly)
+	assert.ErrorIs(t, db1.CompareAndSwap([]byte("key-1"), []byte("val-81"), []byte("val")), bitcask_errors.ErrReadOnly)
+	batch := NewBatch()
+	assert.Nil(t, batch.Put([]byte("key-1"), []byte("val")))
+	assert.ErrorIs(t, db1.WriteBatch(batch), bitcask_errors.ErrReadOnly)
+	assert.ErrorIs(t, db1.Update(func(tx *Tx) error {
+		return tx.Set([]byte("key-1"), []byte("val"))
+	}), bitcask_errors.ErrReadOnly)
+	assert.ErrorIs(t, db1.Merge(), bitcask_errors.ErrReadOnly)
+	assert.Nil(t, db1.Sync())
+
+	db1.Close()
+	db2.Close()
+
+	assert.Equal(t, contentsBefore, dirContents(t, tempDir))
+
+	// A DB which does not exist is not created
+	_, err = Open("missing-db", readOnlyCfg)
+	assert.True(t, os.IsNotExist(err))
+	_, err = os.Stat(filepath.Join(tempDir, "missing-db"))
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestReadOnlyWhileOpenForWriting(t *testing.T) {
+	tempDir := t.TempDir()
+	segmentDir := filepath.Join(tempDir, "test-db", "segments")
+	cfg := &config.Config{
+		DataDirectory: tempDir,
+		SegmentSize:   1 * config.KB,
+	}
+	readOnlyCfg := &config.Config{
+		DataDirectory: tempDir,
+		SegmentSize:   1 * config.KB,
+		ReadOnly:      true,
+	}
+
+	db, err := Open("test-db", cfg)
+
+	assert.Nil(t, err)
+
+	for i := 0; i < 100; i++ {
+		assert.Nil(t, db.Set([]byte(fmt.Sprintf("key-%d", i%20)), []byte(fmt.Sprintf("val-%d", i))))
+	}
+
+	// Readers see the DB as it was when they opened it, while it is written to and merged
+	reader, err := Open("test-db", readOnlyCfg)
+
+	assert.Nil(t, err)
+
+	_, err = Open("test-db", cfg)
+	assert.ErrorIs(t, err, bitcask_errors.ErrDatabaseLocked)
+
+	segmentsBefore := segmentFiles(t, segmentDir)
+	for i := 0; i < 20; i++ {
+		assert.Nil(t, db.Set([]byte(fmt.Sprintf("key-%d", i)), []byte("new-val")))
+	}
+	assert.Nil(t, db.Merge())
+	for _, path := range segmentsBefore {
+		assert.FileExists(t, path)
+	}
+
+	for i := 80; i < 100; i++ {
+		val, err := reader.Get([]byte(fmt.Sprintf("key-%d", i%20)))
+		assert.Nil(t, err)
+		assert.Equal(t, []byte(fmt.Sprintf("val-%d", i)), val)
+	}
+
+	// A reader opened after the merge reads the merged segments
+	reader2, err := Open("test-db", readOnlyCfg)
+
+	assert.Nil(t, err)
+
+	val, err := reader2.Get([]byte("key-1"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("new-val"), val)
+	reader2.Close()
+	reader.Close()
+
+	// The merged away segments are deleted once no reader is open
+	db.Close()
+	for _, path := range segmentsBefore {
+		if !slices.Contains(segmentFiles(t, segmentDir), path) {
+			continue
+		}
+		// Only the segment which was being written to when the merge started is left
+		assert.Equal(t, segmentsBefore[len(segmentsBefore)-1], path)
+	}
+
+	db, err = Open("test-db", cfg)
+
+	assert.Nil(t, err)
+
+	defer db.Close()
+
+	val, err = db.Get([]byte("key-1"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("new-val"), val)
+}
+
+func TestReadOnlyWithTornTailRecord(t *testing.T) {
+	tempDir := t.TempDir()
+
+	db, err := Open("test-db", &config.Config{
+		DataDirectory: tempDir,
+	})
+
+	assert.Nil(t, err)
+
+	assert.Nil(t, db.Set([]byte("key-1"), []byte("val-1")))
+	assert.Nil(t, db.Set([]byte("key-2"), []byte("val-2")))
+	db.Close()
+
+	// As if the store went down in the middle of writing the last record
+	path := segmentFiles(t, filepath.Join(tempDir, "test-db", "segments"))[0]
+	assert.Nil(t, os.Remove(path+".hint"))
+	fileInfo, err := os.Stat(path)
+	assert.Nil(t, err)
+	assert.Nil(t, os.Truncate(path, fileInfo.Size()-2))
+	contentsBefore := dirContents(t, tempDir)
+
+	db, err = Open("test-db", &config.Config{
+		DataDirectory: tempDir,
+		ReadOnly:      true,
+	})
+
+	assert.Nil(t, err)
+
+	val, err := db.Get([]byte("key-1"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("val-1"), val)
+	_, err = db.Get([]byte("key-2"))
+	assert.ErrorIs(t, err, bitcask_errors.ErrKeyNotFound)
+	db.Close()
+
+	assert.Equal(t, contentsBefore, dirContents(t, tempDir))
+}
@@ -0,0 +1,120 @@
+package bitcask
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/nitin-goyal19/bitcask/config"
+	bitcask_errors "github.com/nitin-goyal19/bitcask/errors"
+	segmentstore "github.com/nitin-goyal19/bitcask/internal/segment-store"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRepair(t *testing.T) {
+	tempDir := t.TempDir()
+	dbDir := filepath.Join(tempDir, "test-db")
+	segmentDir := filepath.Join(dbDir, "segments")
+	cfg := &config.Config{
+		DataDirectory: tempDir,
+		SegmentSize:   1 * config.KB,
+	}
+
+	db, err := Open("test-db", cfg)
+
+	assert.Nil(t, err)
+
+	val := func(i int) []byte {
+		return []byte(fmt.Sprintf("val-%d-%s", i, strings.Repeat("x", 100)))
+	}
+	for i := 0; i < 30; i++ {
+		assert.Nil(t, db.Set([]byte(fmt.Sprintf("key-%d", i)), val(i)))
+	}
+	assert.Nil(t, db.Set([]byte("key-2"), []byte("new-val")))
+	db.Close()
+
+	// Damage the values of key-1 and key-2 in the first segment, and remove the hint
+	// files so that opening the DB reads the damaged records
+	path := filepath.Join(segmentDir, "1")
+	file, err := os.Open(path)
+	assert.Nil(t, err)
+	fileInfo, err := file.Stat()
+	assert.Nil(t, err)
+	var frames []segmentstore.Frame
+	for frame := range segmentstore.ReadFrames(file, segmentstore.SegmentHeaderSize, fileInfo.Size()) {
+		frames = append(frames, frame)
+	}
+	file.Close()
+	assert.Greater(t, len(frames), 3)
+
+	content, err := os.ReadFile(path)
+	assert.Nil(t, err)
+	content[frames[1].Offset+frames[1].Size-1] ^= 0xff
+	content[frames[2].Offset+frames[2].Size-1] ^= 0xff
+	assert.Nil(t, os.WriteFile(path, content, 0644))
+	hintFiles, err := filepath.Glob(filepath.Join(segmentDir, "*.hint"))
+	assert.Nil(t, err)
+	for _, hintFile := range hintFiles {
+		assert.Nil(t, os.Remove(hintFile))
+	}
+
+	_, err = Open("test-db", cfg)
+	assert.ErrorIs(t, err, bitcask_errors.ErrCrcVerificationFailed)
+
+	// A dry run reports the damage without changing anything
+	contents := dirContents(t, dbDir)
+	report, err := Repair("test-db", &config.Config{
+		DataDirectory: tempDir,
+		SegmentSize:   1 * config.KB,
+		ReadOnly:      true,
+	})
+
+	assert.Nil(t, err)
+	assert.True(t, report.DryRun)
+	assert.Len(t, report.DamagedSegments, 1)
+	assert.Equal(t, [][]byte{[]byte("key-1")}, report.LostKeys)
+	assert.Equal(t, "", report.QuarantineDir)
+	assert.Equal(t, contents, dirContents(t, dbDir))
+
+	report, err = Repair("test-db", cfg)
+
+	assert.Nil(t, err)
+	assert.False(t, report.DryRun)
+	assert.Len(t, report.DamagedSegments, 1)
+	damaged := report.DamagedSegments[0]
+	assert.Equal(t, segmentstore.SegmentId(1), damaged.SegmentId)
+	// Damaged frames next to each other make up one damaged region
+	assert.Len(t, damaged.Regions, 1)
+	assert.Equal(t, frames[1].Offset, damaged.Regions[0].Offset)
+	assert.Equal(t, frames[1].Size+frames[2].Size, damaged.Regions[0].Size)
+	assert.NotZero(t, damaged.NewSegmentId)
+	assert.Equal(t, len(frames)-2, report.SalvagedRecords)
+	assert.Len(t, report.LostRecords, 2)
+	assert.Equal(t, [][]byte{[]byte("key-1")}, report.LostKeys)
+
+	assert.FileExists(t, filepath.Join(report.QuarantineDir, "1"))
+	assert.NoFileExists(t, path)
+
+	db, err = Open("test-db", cfg)
+
+	assert.Nil(t, err)
+
+	defer db.Close()
+
+	assert.Nil(t, db.Verify())
+	_, err = db.Get([]byte("key-1"))
+	assert.ErrorIs(t, err, bitcask_errors.ErrKeyNotFound)
+	got, err := db.Get([]byte("key-2"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("new-val"), got)
+	for i := 0; i < 30; i++ {
+		if i == 1 || i == 2 {
+			continue
+		}
+		got, err := db.Get([]byte(fmt.Sprintf("key-%d", i)))
+		assert.Nil(t, err)
+		assert.Equal(t, val(i), got)
+	}
+}
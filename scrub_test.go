@@ -0,0 +1,138 @@
+package bitcask
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nitin-goyal19/bitcask/config"
+	bitcask_errors "github.com/nitin-goyal19/bitcask/errors"
+	segmentstore "github.com/nitin-goyal19/bitcask/internal/segment-store"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestScrubber(t *testing.T) {
+	tempDir := t.TempDir()
+	segmentDir := filepath.Join(tempDir, "test-db", "segments")
+
+	db, err := Open("test-db", &config.Config{
+		DataDirectory: tempDir,
+		SegmentSize:   1 * config.KB,
+	})
+
+	assert.Nil(t, err)
+
+	val := func(i int) []byte {
+		return []byte(fmt.Sprintf("val-%d-%s", i, strings.Repeat("x", 100)))
+	}
+	for i := 0; i < 30; i++ {
+		assert.Nil(t, db.Set([]byte(fmt.Sprintf("key-%d", i)), val(i)))
+	}
+	db.Close()
+
+	// Damage the value of key-1, which goes unnoticed when the DB is opened from hint files
+	path := filepath.Join(segmentDir, "1")
+	file, err := os.Open(path)
+	assert.Nil(t, err)
+	fileInfo, err := file.Stat()
+	assert.Nil(t, err)
+	var frames []segmentstore.Frame
+	for frame := range segmentstore.ReadFrames(file, segmentstore.SegmentHeaderSize, fileInfo.Size()) {
+		frames = append(frames, frame)
+	}
+	file.Close()
+	assert.Greater(t, len(frames), 2)
+
+	content, err := os.ReadFile(path)
+	assert.Nil(t, err)
+	content[frames[1].Offset+frames[1].Size-1] ^= 0xff
+	assert.Nil(t, os.WriteFile(path, content, 0644))
+
+	var mu sync.Mutex
+	var corruptions []config.Corruption
+	db, err = Open("test-db", &config.Config{
+		DataDirectory:   tempDir,
+		SegmentSize:     1 * config.KB,
+		ScrubInterval:   10 * time.Millisecond,
+		ScrubRate:       1 * config.GB,
+		MarkCorruptKeys: true,
+		OnCorruption: func(corruption config.Corruption) {
+			mu.Lock()
+			defer mu.Unlock()
+			corruptions = append(corruptions, corruption)
+		},
+	})
+
+	assert.Nil(t, err)
+
+	defer db.Close()
+
+	// A corrupt record is reported once, however many passes find it
+	assert.Eventually(t, func() bool {
+		return db.Stats().Scrub.Passes >= 2
+	}, 5*time.Second, 10*time.Millisecond)
+	stats := db.Stats().Scrub
+	assert.Equal(t, 1, stats.Corruptions)
+	assert.False(t, stats.LastPassEnd.IsZero())
+	assert.Greater(t, stats.BytesToScan, int64(0))
+
+	mu.Lock()
+	assert.Len(t, corruptions, 1)
+	assert.Equal(t, int64(1), corruptions[0].SegmentId)
+	assert.Equal(t, frames[1].Offset, corruptions[0].Offset)
+	assert.Equal(t, [][]byte{[]byte("key-1")}, corruptions[0].Keys)
+	assert.ErrorIs(t, corruptions[0].Err, bitcask_errors.ErrCrcVerificationFailed)
+	mu.Unlock()
+
+	_, err = db.Get([]byte("key-1"))
+	assert.ErrorIs(t, err, bitcask_errors.ErrCorrupted)
+	assert.Contains(t, err.Error(), fmt.Sprintf("offset %d of segment 1", frames[1].Offset))
+	got, err := db.Get([]byte("key-0"))
+	assert.Nil(t, err)
+	assert.Equal(t, val(0), got)
+
+	// Overwriting the key replaces its corrupt value
+	assert.Nil(t, db.Set([]byte("key-1"), []byte("new-val")))
+	got, err = db.Get([]byte("key-1"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("new-val"), got)
+}
+
+func TestScrubberRateLimit(t *testing.T) {
+	tempDir := t.TempDir()
+
+	db, err := Open("test-db", &config.Config{
+		DataDirectory: tempDir,
+		SegmentSize:   1 * config.KB,
+	})
+
+	assert.Nil(t, err)
+
+	for i := 0; i < 100; i++ {
+		assert.Nil(t, db.Set([]byte(fmt.Sprintf("key-%d", i)), []byte(strings.Repeat("x", 100))))
+	}
+	db.Close()
+
+	db, err = Open("test-db", &config.Config{
+		DataDirectory: tempDir,
+		SegmentSize:   1 * config.KB,
+		ScrubInterval: time.Millisecond,
+		ScrubRate:     10 * config.KB,
+	})
+
+	assert.Nil(t, err)
+
+	defer db.Close()
+
+	// Scanning more than 10 KB at 10 KB per second takes more than a second
+	time.Sleep(500 * time.Millisecond)
+	stats := db.Stats().Scrub
+	assert.Equal(t, 0, stats.Passes)
+	assert.Greater(t, stats.BytesToScan, int64(10*config.KB))
+	assert.Greater(t, stats.BytesScanned, int64(0))
+	assert.Less(t, stats.BytesScanned, stats.BytesToScan)
+}
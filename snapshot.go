@@ -0,0 +1,68 @@
+package bitcask
+
+import (
+	"iter"
+
+	segmentstore "github.com/nitin-goyal19/bitcask/internal/segment-store"
+)
+
+// Snapshot is a read-only view of the DB frozen at the time Snapshot was called.
+// Writes, deletes and merges made afterwards are not seen through it.
+type Snapshot struct {
+	snapshot *segmentstore.Snapshot
+}
+
+// Snapshot returns a point-in-time view of the DB. The segments holding the values
+// seen by the snapshot are kept on disk, even if merged away, till it is closed,
+// so snapshots should not be held open for longer than needed.
+func (db *Bitcask) Snapshot() *Snapshot {
+	return &Snapshot{snapshot: db.segmentStore.Snapshot()}
+}
+
+func (snapshot *Snapshot) Get(key []byte) ([]byte, error) {
+	return snapshot.snapshot.Read(key)
+}
+
+// Len returns the number of keys in the snapshot.
+func (snapshot *Snapshot) Len() int {
+	return snapshot.snapshot.Len()
+}
+
+// Keys returns an iterator over the keys of the snapshot.
+func (snapshot *Snapshot) Keys() iter.Seq[[]byte] {
+	entries := snapshot.snapshot.Entries()
+	return func(yield func([]byte) bool) {
+		for _, entry := range entries {
+			if !yield(entry.Key) {
+				return
+			}
+		}
+	}
+}
+
+// ForEach calls fn with every key of the snapshot along with its value. It stops at
+// the first error returned by fn or hit while reading a value, and returns it.
+func (snapshot *Snapshot) ForEach(fn func(key, value []byte) error) error {
+	return forEachEntry(snapshot.snapshot, snapshot.snapshot.Entries(), fn)
+}
+
+// All returns an iterator over the keys of the snapshot along with their values.
+func (snapshot *Snapshot) All() iter.Seq2[[]byte, []byte] {
+	return iterateEntries(snapshot.snapshot, snapshot.snapshot.Entries())
+}
+
+// Scan is Bitcask.Scan over the snapshot.
+func (snapshot *Snapshot) Scan(prefix []byte, reverse bool) iter.Seq2[[]byte, []byte] {
+	return snapshot.Range(prefix, segmentstore.PrefixEnd(prefix), reverse)
+}
+
+// Range is Bitcask.Range over the snapshot.
+func (snapshot *Snapshot) Range(start, end []byte, reverse bool) iter.Seq2[[]byte, []byte] {
+	return iterateEntries(snapshot.snapshot, snapshot.snapshot.RangeEntries(start, end, reverse))
+}
+
+// Close releases the segments held by the snapshot. Reads made through a closed
+// snapshot fail with ErrSnapshotClosed.
+func (snapshot *Snapshot) Close() error {
+	return snapshot.snapshot.Close()
+}
@@ -0,0 +1,120 @@
+package bitcask
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/nitin-goyal19/bitcask/config"
+	bitcask_errors "github.com/nitin-goyal19/bitcask/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func retiredFiles(t *testing.T, segmentDir string) []string {
+	files, err := os.ReadDir(segmentDir)
+	assert.Nil(t, err)
+
+	var retired []string
+	for _, file := range files {
+		if strings.HasSuffix(file.Name(), ".retired") {
+			retired = append(retired, file.Name())
+		}
+	}
+	return retired
+}
+
+func TestSnapshot(t *testing.T) {
+	tempDir := t.TempDir()
+	segmentDir := filepath.Join(tempDir, "test-db", "segments")
+	db, keyValMap := openWithKeysIn(t, tempDir, 200)
+	defer db.Close()
+
+	snapshot := db.Snapshot()
+
+	// Change every key after the snapshot is taken, and merge the old values away
+	for i := 0; i < 200; i++ {
+		key := []byte(fmt.Sprintf("key-%03d", i))
+		if i%2 == 0 {
+			_, err := db.Delete(key)
+			assert.Nil(t, err)
+		} else {
+			assert.Nil(t, db.Set(key, []byte("new-val")))
+		}
+	}
+	assert.Nil(t, db.Set([]byte("new-key"), []byte("new-val")))
+	assert.Nil(t, db.Merge())
+	assert.NotEmpty(t, retiredFiles(t, segmentDir))
+
+	assert.Equal(t, len(keyValMap), snapshot.Len())
+	for key, val := range keyValMap {
+		storedVal, err := snapshot.Get([]byte(key))
+		assert.Nil(t, err)
+		assert.Equal(t, val, storedVal)
+	}
+	_, err := snapshot.Get([]byte("new-key"))
+	assert.ErrorIs(t, err, bitcask_errors.ErrKeyNotFound)
+
+	storedKeyValMap := make(map[string][]byte)
+	for key, value := range snapshot.All() {
+		storedKeyValMap[string(key)] = value
+	}
+	assert.Equal(t, keyValMap, storedKeyValMap)
+
+	var scannedKeys []string
+	for key := range snapshot.Scan([]byte("key-00"), false) {
+		scannedKeys = append(scannedKeys, string(key))
+	}
+	assert.Equal(t, []string{"key-000", "key-001", "key-002", "key-003", "key-004", "key-005", "key-006", "key-007", "key-008", "key-009"}, scannedKeys)
+
+	// The DB itself sees the new values
+	val, err := db.Get([]byte("key-001"))
+	assert.Nil(t, err)
+	assert.Equal(t, "new-val", string(val))
+
+	assert.Nil(t, snapshot.Close())
+	assert.Nil(t, snapshot.Close())
+	assert.Empty(t, retiredFiles(t, segmentDir))
+
+	_, err = snapshot.Get([]byte("key-001"))
+	assert.ErrorIs(t, err, bitcask_errors.ErrSnapshotClosed)
+	assert.ErrorIs(t, snapshot.ForEach(func(key, value []byte) error { return nil }), bitcask_errors.ErrSnapshotClosed)
+}
+
+func TestRetiredSegmentsAreRemovedOnOpen(t *testing.T) {
+	tempDir := t.TempDir()
+	segmentDir := filepath.Join(tempDir, "test-db", "segments")
+	db, keyValMap := openWithKeysIn(t, tempDir, 200)
+
+	snapshot := db.Snapshot()
+	for key := range keyValMap {
+		assert.Nil(t, db.Set([]byte(key), []byte("new-val")))
+	}
+	assert.Nil(t, db.Merge())
+	retired := retiredFiles(t, segmentDir)
+	assert.NotEmpty(t, retired)
+
+	// Keep a copy of a retired segment around, as if the process died with the snapshot open
+	content, err := os.ReadFile(filepath.Join(segmentDir, retired[0]))
+	assert.Nil(t, err)
+	snapshot.Close()
+	db.Close()
+	assert.Nil(t, os.WriteFile(filepath.Join(segmentDir, retired[0]), content, 0644))
+
+	db, err = Open("test-db", &config.Config{
+		DataDirectory: tempDir,
+		SegmentSize:   16 * config.KB,
+	})
+
+	assert.Nil(t, err)
+
+	defer db.Close()
+
+	assert.Empty(t, retiredFiles(t, segmentDir))
+	for key := range keyValMap {
+		val, err := db.Get([]byte(key))
+		assert.Nil(t, err)
+		assert.Equal(t, "new-val", string(val))
+	}
+}
@@ -0,0 +1,163 @@
+package bitcask
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/nitin-goyal19/bitcask/config"
+	bitcask_errors "github.com/nitin-goyal19/bitcask/errors"
+	testutils "github.com/nitin-goyal19/bitcask/internal/test-utils"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSetWithTTL(t *testing.T) {
+	db, err := Open("test-db", &config.Config{
+		DataDirectory: t.TempDir(),
+	})
+
+	assert.Nil(t, err)
+
+	defer db.Close()
+
+	assert.ErrorIs(t, db.SetWithTTL([]byte("key"), []byte("val"), 0), bitcask_errors.ErrInvalidTTL)
+
+	assert.Nil(t, db.Set([]byte("persistent-key"), []byte("val")))
+	assert.Nil(t, db.SetWithTTL([]byte("key"), []byte("val"), 100*time.Millisecond))
+
+	val, err := db.Get([]byte("key"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("val"), val)
+
+	ttl, err := db.TTL([]byte("key"))
+	assert.Nil(t, err)
+	assert.Greater(t, ttl, time.Duration(0))
+	assert.LessOrEqual(t, ttl, 100*time.Millisecond)
+
+	ttl, err = db.TTL([]byte("persistent-key"))
+	assert.Nil(t, err)
+	assert.Equal(t, NoExpiry, ttl)
+
+	time.Sleep(150 * time.Millisecond)
+
+	_, err = db.Get([]byte("key"))
+	assert.ErrorIs(t, err, bitcask_errors.ErrKeyNotFound)
+	_, err = db.TTL([]byte("key"))
+	assert.ErrorIs(t, err, bitcask_errors.ErrKeyNotFound)
+	assert.ErrorIs(t, db.Persist([]byte("key")), bitcask_errors.ErrKeyNotFound)
+	_, err = db.Delete([]byte("key"))
+	assert.ErrorIs(t, err, bitcask_errors.ErrKeyNotFound)
+	assert.Nil(t, db.SetIfNotExists([]byte("key"), []byte("new-val")))
+
+	var keys []string
+	for key := range db.Keys() {
+		keys = append(keys, string(key))
+	}
+	assert.ElementsMatch(t, []string{"persistent-key", "key"}, keys)
+}
+
+func TestPersist(t *testing.T) {
+	tempDir := t.TempDir()
+	cfg := &config.Config{
+		DataDirectory: tempDir,
+	}
+	db, err := Open("test-db", cfg)
+
+	assert.Nil(t, err)
+
+	assert.Nil(t, db.SetWithTTL([]byte("key"), []byte("val"), 200*time.Millisecond))
+	assert.Nil(t, db.Persist([]byte("key")))
+	assert.Nil(t, db.Persist([]byte("key")))
+
+	ttl, err := db.TTL([]byte("key"))
+	assert.Nil(t, err)
+	assert.Equal(t, NoExpiry, ttl)
+
+	time.Sleep(250 * time.Millisecond)
+	db.Close()
+
+	db, err = Open("test-db", cfg)
+
+	assert.Nil(t, err)
+
+	defer db.Close()
+
+	val, err := db.Get([]byte("key"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("val"), val)
+}
+
+// writeExpiringKeys sets 500 keys, overwriting every second one with a value which
+// expires shortly, and waits till those values have expired.
+func writeExpiringKeys(t *testing.T, db *Bitcask) {
+	val := make([]byte, 100)
+	for i := 0; i < 500; i++ {
+		key := []byte(fmt.Sprintf("key-%03d", i))
+		assert.Nil(t, db.Set(key, val))
+		if i%2 == 0 {
+			// An expired value must not bring back the value it overwrote
+			assert.Nil(t, db.SetWithTTL(key, val, 100*time.Millisecond))
+		}
+	}
+	time.Sleep(150 * time.Millisecond)
+}
+
+func checkExpiredKeys(t *testing.T, db *Bitcask) {
+	for i := 0; i < 500; i++ {
+		_, err := db.Get([]byte(fmt.Sprintf("key-%03d", i)))
+		if i%2 == 0 {
+			assert.ErrorIs(t, err, bitcask_errors.ErrKeyNotFound)
+		} else {
+			assert.Nil(t, err)
+		}
+	}
+}
+
+func TestExpiredKeysOnReOpen(t *testing.T) {
+	cfg := &config.Config{
+		DataDirectory: t.TempDir(),
+		SegmentSize:   16 * config.KB,
+	}
+	db, err := Open("test-db", cfg)
+
+	assert.Nil(t, err)
+
+	writeExpiringKeys(t, db)
+	db.Close()
+
+	db, err = Open("test-db", cfg)
+
+	assert.Nil(t, err)
+
+	defer db.Close()
+	checkExpiredKeys(t, db)
+}
+
+func TestMergeDropsExpiredKeys(t *testing.T) {
+	tempDir := t.TempDir()
+	cfg := &config.Config{
+		DataDirectory: tempDir,
+		SegmentSize:   16 * config.KB,
+	}
+	db, err := Open("test-db", cfg)
+
+	assert.Nil(t, err)
+
+	writeExpiringKeys(t, db)
+	checkExpiredKeys(t, db)
+
+	segmentDir := filepath.Join(tempDir, "test-db", "segments")
+	sizeBeforeMerge := testutils.DirSize(t, segmentDir)
+	assert.Nil(t, db.Merge())
+	assert.Less(t, testutils.DirSize(t, segmentDir), sizeBeforeMerge/2)
+	checkExpiredKeys(t, db)
+	db.Close()
+
+	db, err = Open("test-db", cfg)
+
+	assert.Nil(t, err)
+
+	defer db.Close()
+	checkExpiredKeys(t, db)
+}
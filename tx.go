@@ -0,0 +1,130 @@
+package bitcask
+
+import (
+	"errors"
+	"fmt"
+	"math"
+
+	bitcask_errors "github.com/nitin-goyal19/bitcask/errors"
+)
+
+// Tx is a transaction run by Update or View. Writes made by a transaction are seen
+// by its own reads, and are applied to the DB all together when it commits.
+//
+// All reads of a transaction see the DB as it was when the transaction started. A
+// read of a key written by someone else since then, or of a missing key while keys
+// have been deleted since then, fails with ErrConflict rather than return a value
+// which would not be consistent with the other reads of the transaction.
+//
+// Transactions are optimistic: they take no locks, and an Update fails with
+// ErrConflict, writing nothing, if any key read by the transaction has been
+// written by someone else since the transaction started. Updates are thereby
+// serializable with respect to the keys they read, and are to be retried when
+// they fail with ErrConflict.
+type Tx struct {
+	db       *Bitcask
+	writable bool
+	closed   bool
+	startSeq uint64 // sequence number of the last write made before the transaction started
+	// version of each key read from the DB, 0 if the key was not found
+	readVersions map[string]uint64
+	writes       *Batch
+}
+
+// Update runs fn in a read-write transaction, and commits the transaction if fn
+// returns nil. The error returned by fn, or by the commit, is returned.
+func (db *Bitcask) Update(fn func(tx *Tx) error) error {
+	tx := &Tx{
+		db:           db,
+		writable:     true,
+		startSeq:     db.segmentStore.LastSeq(),
+		readVersions: make(map[string]uint64),
+		writes:       NewBatch(),
+	}
+	defer tx.close()
+
+	if err := fn(tx); err != nil {
+		return err
+	}
+	return tx.commit()
+}
+
+// View runs fn in a read-only transaction and returns the error returned by fn.
+func (db *Bitcask) View(fn func(tx *Tx) error) error {
+	tx := &Tx{db: db, startSeq: db.segmentStore.LastSeq()}
+	defer tx.close()
+
+	return fn(tx)
+}
+
+// Get returns the value of key as written by the transaction, or else as stored in the DB.
+// It fails with ErrConflict if the DB has changed in a way the transaction can not see,
+// as described for Tx.
+func (tx *Tx) Get(key []byte) ([]byte, error) {
+	if tx.closed {
+		return nil, bitcask_errors.ErrTxClosed
+	}
+
+	if tx.writable {
+		if record, ok := tx.writes.get(key); ok {
+			if record.IsTombstone() {
+				return nil, bitcask_errors.ErrKeyNotFound
+			}
+			return record.Val, nil
+		}
+	}
+
+	value, version, error := tx.db.segmentStore.ReadVersion(key, tx.startSeq)
+	if error != nil && !errors.Is(error, bitcask_errors.ErrKeyNotFound) {
+		return nil, error
+	}
+
+	if tx.writable {
+		if _, ok := tx.readVersions[string(key)]; !ok {
+			tx.readVersions[string(key)] = version
+		}
+	}
+	return value, error
+}
+
+// Set sets key to val when the transaction commits.
+func (tx *Tx) Set(key []byte, val []byte) error {
+	if err := tx.checkWritable(); err != nil {
+		return err
+	}
+	return tx.writes.Put(key, val)
+}
+
+// Delete deletes key when the transaction commits. Deleting a key which does not
+// exist is not an error.
+func (tx *Tx) Delete(key []byte) error {
+	if err := tx.checkWritable(); err != nil {
+		return err
+	}
+	return tx.writes.Delete(key)
+}
+
+func (tx *Tx) checkWritable() error {
+	if tx.closed {
+		return bitcask_errors.ErrTxClosed
+	}
+	if !tx.writable {
+		return bitcask_errors.ErrTxReadOnly
+	}
+	return nil
+}
+
+func (tx *Tx) commit() error {
+	if tx.writes.Len() == 0 {
+		return nil
+	}
+
+	if tx.writes.size > math.MaxUint32 {
+		return fmt.Errorf("transaction can not write more than %d bytes", math.MaxUint32)
+	}
+	return tx.db.segmentStore.WriteBatchIfUnchanged(tx.writes.records, tx.readVersions)
+}
+
+func (tx *Tx) close() {
+	tx.closed = true
+}
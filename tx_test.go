@@ -0,0 +1,231 @@
+package bitcask
+
+import (
+	"errors"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/nitin-goyal19/bitcask/config"
+	bitcask_errors "github.com/nitin-goyal19/bitcask/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUpdateAndView(t *testing.T) {
+	db, err := Open("test-db", &config.Config{
+		DataDirectory: t.TempDir(),
+	})
+
+	assert.Nil(t, err)
+
+	defer db.Close()
+
+	assert.Nil(t, db.Set([]byte("key-1"), []byte("val-1")))
+	assert.Nil(t, db.Set([]byte("key-2"), []byte("val-2")))
+
+	err = db.Update(func(tx *Tx) error {
+		assert.Nil(t, tx.Set([]byte("key-1"), []byte("new-val-1")))
+		assert.Nil(t, tx.Delete([]byte("key-2")))
+		assert.Nil(t, tx.Set([]byte("key-3"), []byte("val-3")))
+
+		// Reads see the writes of the transaction
+		val, err := tx.Get([]byte("key-1"))
+		assert.Nil(t, err)
+		assert.Equal(t, "new-val-1", string(val))
+		_, err = tx.Get([]byte("key-2"))
+		assert.ErrorIs(t, err, bitcask_errors.ErrKeyNotFound)
+
+		// while the DB does not, till the transaction commits
+		val, err = db.Get([]byte("key-1"))
+		assert.Nil(t, err)
+		assert.Equal(t, "val-1", string(val))
+		_, err = db.Get([]byte("key-3"))
+		assert.ErrorIs(t, err, bitcask_errors.ErrKeyNotFound)
+		return nil
+	})
+	assert.Nil(t, err)
+
+	err = db.View(func(tx *Tx) error {
+		val, err := tx.Get([]byte("key-1"))
+		assert.Nil(t, err)
+		assert.Equal(t, "new-val-1", string(val))
+		_, err = tx.Get([]byte("key-2"))
+		assert.ErrorIs(t, err, bitcask_errors.ErrKeyNotFound)
+		val, err = tx.Get([]byte("key-3"))
+		assert.Nil(t, err)
+		assert.Equal(t, "val-3", string(val))
+
+		assert.ErrorIs(t, tx.Set([]byte("key-1"), []byte("val")), bitcask_errors.ErrTxReadOnly)
+		assert.ErrorIs(t, tx.Delete([]byte("key-1")), bitcask_errors.ErrTxReadOnly)
+		return nil
+	})
+	assert.Nil(t, err)
+
+	// Writes of a transaction whose function fails are discarded
+	errAbort := errors.New("abort")
+	var leakedTx *Tx
+	err = db.Update(func(tx *Tx) error {
+		leakedTx = tx
+		assert.Nil(t, tx.Set([]byte("key-4"), []byte("val-4")))
+		return errAbort
+	})
+	assert.ErrorIs(t, err, errAbort)
+	_, err = db.Get([]byte("key-4"))
+	assert.ErrorIs(t, err, bitcask_errors.ErrKeyNotFound)
+
+	_, err = leakedTx.Get([]byte("key-1"))
+	assert.ErrorIs(t, err, bitcask_errors.ErrTxClosed)
+	assert.ErrorIs(t, leakedTx.Set([]byte("key-1"), nil), bitcask_errors.ErrTxClosed)
+}
+
+func TestUpdateConflict(t *testing.T) {
+	db, err := Open("test-db", &config.Config{
+		DataDirectory: t.TempDir(),
+	})
+
+	assert.Nil(t, err)
+
+	defer db.Close()
+
+	assert.Nil(t, db.Set([]byte("key"), []byte("val")))
+	assert.Nil(t, db.Set([]byte("deleted-key"), []byte("val")))
+
+	for _, write := range []func(){
+		func() { assert.Nil(t, db.Set([]byte("key"), []byte("other-val"))) },
+		func() { db.Delete([]byte("deleted-key")) },
+		func() { assert.Nil(t, db.Set([]byte("new-key"), []byte("val"))) },
+	} {
+		err = db.Update(func(tx *Tx) error {
+			tx.Get([]byte("key"))
+			tx.Get([]byte("deleted-key"))
+			tx.Get([]byte("new-key"))
+			write()
+			return tx.Set([]byte("result"), []byte("val"))
+		})
+		assert.ErrorIs(t, err, bitcask_errors.ErrConflict)
+		_, err = db.Get([]byte("result"))
+		assert.ErrorIs(t, err, bitcask_errors.ErrKeyNotFound)
+	}
+
+	// Writes to keys which were not read do not conflict
+	err = db.Update(func(tx *Tx) error {
+		tx.Get([]byte("key"))
+		assert.Nil(t, db.Set([]byte("other-key"), []byte("val")))
+		return tx.Set([]byte("result"), []byte("val"))
+	})
+	assert.Nil(t, err)
+}
+
+func TestTxReadsAreConsistent(t *testing.T) {
+	db, err := Open("test-db", &config.Config{
+		DataDirectory: t.TempDir(),
+	})
+
+	assert.Nil(t, err)
+
+	defer db.Close()
+
+	reset := func() {
+		assert.Nil(t, db.Set([]byte("from"), []byte("10")))
+		assert.Nil(t, db.Set([]byte("to"), []byte("0")))
+	}
+	transfer := func() {
+		batch := NewBatch()
+		batch.Put([]byte("from"), []byte("0"))
+		batch.Put([]byte("to"), []byte("10"))
+		assert.Nil(t, db.WriteBatch(batch))
+	}
+
+	// A transfer committed between two reads fails the second one rather than
+	// have it see the transfer while the first one did not
+	reset()
+	err = db.View(func(tx *Tx) error {
+		val, err := tx.Get([]byte("from"))
+		assert.Nil(t, err)
+		assert.Equal(t, "10", string(val))
+		transfer()
+		_, err = tx.Get([]byte("to"))
+		return err
+	})
+	assert.ErrorIs(t, err, bitcask_errors.ErrConflict)
+
+	reset()
+	err = db.Update(func(tx *Tx) error {
+		tx.Get([]byte("from"))
+		transfer()
+		_, err := tx.Get([]byte("to"))
+		assert.ErrorIs(t, err, bitcask_errors.ErrConflict)
+		return tx.Set([]byte("total"), []byte("10"))
+	})
+	assert.ErrorIs(t, err, bitcask_errors.ErrConflict)
+	_, err = db.Get([]byte("total"))
+	assert.ErrorIs(t, err, bitcask_errors.ErrKeyNotFound)
+
+	// as does a key deleted since the transaction started
+	reset()
+	err = db.View(func(tx *Tx) error {
+		deleted, err := db.Delete([]byte("to"))
+		assert.True(t, deleted)
+		assert.Nil(t, err)
+		_, err = tx.Get([]byte("to"))
+		return err
+	})
+	assert.ErrorIs(t, err, bitcask_errors.ErrConflict)
+
+	// Keys written before the transaction started, and keys not found while nothing
+	// has been deleted since, are read as usual
+	reset()
+	err = db.View(func(tx *Tx) error {
+		assert.Nil(t, db.Set([]byte("other-key"), []byte("val")))
+		val, err := tx.Get([]byte("to"))
+		assert.Nil(t, err)
+		assert.Equal(t, "0", string(val))
+		_, err = tx.Get([]byte("missing-key"))
+		assert.ErrorIs(t, err, bitcask_errors.ErrKeyNotFound)
+		return nil
+	})
+	assert.Nil(t, err)
+}
+
+func TestConcurrentCounterUpdates(t *testing.T) {
+	db, err := Open("test-db", &config.Config{
+		DataDirectory: t.TempDir(),
+	})
+
+	assert.Nil(t, err)
+
+	defer db.Close()
+
+	increment := func(tx *Tx) error {
+		count := 0
+		val, err := tx.Get([]byte("counter"))
+		if err == nil {
+			count, err = strconv.Atoi(string(val))
+		}
+		if err != nil && !errors.Is(err, bitcask_errors.ErrKeyNotFound) {
+			return err
+		}
+		return tx.Set([]byte("counter"), []byte(strconv.Itoa(count+1)))
+	}
+
+	const numWriters, numIncrements = 8, 50
+	var wg sync.WaitGroup
+	for i := 0; i < numWriters; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < numIncrements; j++ {
+				err := db.Update(increment)
+				for errors.Is(err, bitcask_errors.ErrConflict) {
+					err = db.Update(increment)
+				}
+				assert.Nil(t, err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	val, err := db.Get([]byte("counter"))
+	assert.Nil(t, err)
+	assert.Equal(t, strconv.Itoa(numWriters*numIncrements), string(val))
+}
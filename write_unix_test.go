@@ -0,0 +1,58 @@
+//go:build unix
+
+package bitcask
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/nitin-goyal19/bitcask/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPartialWriteIsUndone(t *testing.T) {
+	tempDir := t.TempDir()
+	cfg := &config.Config{
+		DataDirectory: tempDir,
+	}
+
+	db, error := Open("test-db", cfg)
+
+	assert.Nil(t, error)
+
+	assert.Nil(t, db.Set([]byte("key-1"), []byte("val-1")))
+	fileInfo, error := os.Stat(filepath.Join(tempDir, "test-db", "segments", "1"))
+	assert.Nil(t, error)
+
+	// Let the file grow by only part of the next record, as a full disk would
+	var limit syscall.Rlimit
+	assert.Nil(t, syscall.Getrlimit(syscall.RLIMIT_FSIZE, &limit))
+	partialLimit := limit
+	partialLimit.Cur = uint64(fileInfo.Size()) + 20
+	assert.Nil(t, syscall.Setrlimit(syscall.RLIMIT_FSIZE, &partialLimit))
+	error = db.Set([]byte("key-2"), make([]byte, 100))
+	assert.Nil(t, syscall.Setrlimit(syscall.RLIMIT_FSIZE, &limit))
+	assert.NotNil(t, error)
+
+	assert.Nil(t, db.Set([]byte("key-3"), []byte("val-3")))
+	got, error := db.Get([]byte("key-3"))
+	assert.Nil(t, error)
+	assert.Equal(t, []byte("val-3"), got)
+	db.Close()
+
+	db, error = Open("test-db", cfg)
+
+	assert.Nil(t, error)
+
+	defer db.Close()
+
+	assert.Nil(t, db.Verify())
+	got, error = db.Get([]byte("key-1"))
+	assert.Nil(t, error)
+	assert.Equal(t, []byte("val-1"), got)
+	got, error = db.Get([]byte("key-3"))
+	assert.Nil(t, error)
+	assert.Equal(t, []byte("val-3"), got)
+}